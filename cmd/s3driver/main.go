@@ -18,28 +18,122 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
 	"os"
+	"time"
 
 	"github.com/yandex-cloud/k8s-csi-s3/pkg/driver"
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/logging"
 )
 
 func init() {
 	flag.Set("logtostderr", "true")
 }
 
+// Flags which only have effect in node mode
+var nodeFlags = []string{"cache-dir", "mount-timeout", "unmount-timeout", "health-check-systemd", "report-volume-status"}
+
+// Flags which only have effect in controller mode
+var controllerFlags = []string{"list-volumes-secret-dir"}
+
 var (
-	endpoint = flag.String("endpoint", "unix://tmp/csi.sock", "CSI endpoint")
-	nodeID   = flag.String("nodeid", "", "node id")
+	endpoint       = flag.String("endpoint", "unix://tmp/csi.sock", "CSI endpoint")
+	configFile     = flag.String("config", "", "YAML config file, reloaded on SIGHUP and when it changes")
+	driverName     = flag.String("driver-name", "", "CSI driver name, ru.yandex.s3.csi if empty, also sets the default plugin directory and prefixes systemd unit names")
+	showVersion    = flag.Bool("version", false, "print the version and exit")
+	mode           = flag.String("mode", driver.ModeAll, "CSI services to serve: controller for the provisioner, node for the node plugin or all")
+	nodeID         = flag.String("nodeid", "", "node id")
+	cacheDir       = flag.String("cache-dir", "", "node directory for volume disk caches, must be the same path on the host, disk cache is disabled if empty")
+	mountersConfig = flag.String("mounters-config", "", "YAML file with exec mounters for external FUSE filesystems")
+	mountTimeout   = flag.Duration("mount-timeout", 10*time.Second, "default time to wait for volumes to be mounted")
+	unmountTimeout = flag.Duration("unmount-timeout", 20*time.Second, "default time to wait for mounter processes to exit on unmount before killing them")
+	metricsAddress = flag.String("metrics-address", "", "address like :9810 to serve Prometheus metrics at /metrics, disabled if empty")
+	healthAddress  = flag.String("health-address", "", "address like :9808 to serve /healthz and /readyz probes, disabled if empty")
+	healthMounters = flag.Bool("health-check-mounters", true, "check that mounter binaries are available in readiness probes of nodes")
+	healthSystemd  = flag.Bool("health-check-systemd", false, "check systemd dbus connectivity in readiness probes, should be enabled on nodes")
+	healthS3Secret = flag.String("health-s3-secret-dir", "", "directory with a mounted S3 secret to check S3 access in readiness probes")
+	listSecret     = flag.String("list-volumes-secret-dir", "", "directory with a mounted S3 secret whose buckets are searched for volumes by ListVolumes, disabled if empty")
+	reportStatus   = flag.Bool("report-volume-status", false, "write statuses of staged volumes to their buckets for ListVolumes, only volumes created by CreateVolume are reported")
+	kubeEvents     = flag.Bool("kube-events", false, "record Kubernetes Events about volume failures on PVCs, PVs and pods")
+	otlpEndpoint   = flag.String("otlp-endpoint", "", "host:port of the OTLP gRPC collector receiving traces, tracing is disabled if empty")
+	shutdownTime   = flag.Duration("shutdown-timeout", 25*time.Second, "time to wait for running calls on SIGTERM before aborting them, should be less than terminationGracePeriodSeconds of the pod")
+	logFormat      = flag.String("log-format", logging.FormatText, "log format, text or json, credentials are redacted in both")
 )
 
 func main() {
 	flag.Parse()
 
-	driver, err := driver.New(*nodeID, *endpoint)
+	if *showVersion {
+		version, commit := driver.Version()
+		fmt.Println(version, commit)
+		os.Exit(0)
+	}
+	if err := logging.Init(*logFormat, "node_id", *nodeID); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateFlags(*mode, *nodeID, setFlags()); err != nil {
+		log.Fatal(err)
+	}
+	driver, err := driver.New(*nodeID, *endpoint, driver.Options{
+		Mode:                 *mode,
+		DriverName:           *driverName,
+		ConfigFile:           *configFile,
+		CacheDir:             *cacheDir,
+		MountersConfig:       *mountersConfig,
+		MountTimeout:         *mountTimeout,
+		UnmountTimeout:       *unmountTimeout,
+		MetricsAddress:       *metricsAddress,
+		HealthAddress:        *healthAddress,
+		HealthCheckMounters:  *healthMounters,
+		HealthCheckSystemd:   *healthSystemd,
+		HealthS3SecretDir:    *healthS3Secret,
+		ListVolumesSecretDir: *listSecret,
+		ReportVolumeStatus:   *reportStatus,
+		KubeEvents:           *kubeEvents,
+		OTLPEndpoint:         *otlpEndpoint,
+		ShutdownTimeout:      *shutdownTime,
+	})
 	if err != nil {
 		log.Fatal(err)
 	}
 	driver.Run()
 	os.Exit(0)
 }
+
+// setFlags returns names of the flags set on the command line
+func setFlags() map[string]bool {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	return set
+}
+
+// validateFlags checks that the flags set on the command line match the mode
+func validateFlags(mode, nodeID string, set map[string]bool) error {
+	switch mode {
+	case driver.ModeController:
+		return rejectFlags(nodeFlags, set, driver.ModeNode)
+	case driver.ModeNode, driver.ModeAll:
+		if nodeID == "" {
+			return fmt.Errorf("--nodeid is required in %s mode", mode)
+		}
+		if mode == driver.ModeNode {
+			return rejectFlags(controllerFlags, set, driver.ModeController)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown mode %q, must be %s, %s or %s", mode, driver.ModeController, driver.ModeNode, driver.ModeAll)
+	}
+}
+
+// rejectFlags returns an error if one of the flags is set, they are only used in mode and all modes
+func rejectFlags(names []string, set map[string]bool, mode string) error {
+	for _, name := range names {
+		if set[name] {
+			return fmt.Errorf("--%s is only used in %s and %s modes", name, mode, driver.ModeAll)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+func TestS3DriverCommand(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "S3DriverCommand")
+}
+
+var _ = Describe("validateFlags", func() {
+	table.DescribeTable("accepts flags of the mode",
+		func(mode, nodeID string, set []string) {
+			Expect(validateFlags(mode, nodeID, flagSet(set))).To(Succeed())
+		},
+		table.Entry("controller", "controller", "", []string{"list-volumes-secret-dir", "shutdown-timeout"}),
+		table.Entry("node", "node", "node-1", []string{"cache-dir", "mount-timeout", "health-check-systemd"}),
+		table.Entry("all", "all", "node-1", []string{"cache-dir", "list-volumes-secret-dir"}),
+	)
+
+	table.DescribeTable("rejects flags of other modes",
+		func(mode, nodeID string, set []string, message string) {
+			Expect(validateFlags(mode, nodeID, flagSet(set))).To(MatchError(message))
+		},
+		table.Entry("unknown mode", "both", "node-1", nil, `unknown mode "both", must be controller, node or all`),
+		table.Entry("node without node ID", "node", "", nil, "--nodeid is required in node mode"),
+		table.Entry("all without node ID", "all", "", nil, "--nodeid is required in all mode"),
+		table.Entry("node flag in controller mode", "controller", "", []string{"unmount-timeout"},
+			"--unmount-timeout is only used in node and all modes"),
+		table.Entry("controller flag in node mode", "node", "node-1", []string{"list-volumes-secret-dir"},
+			"--list-volumes-secret-dir is only used in controller and all modes"),
+	)
+})
+
+func flagSet(names []string) map[string]bool {
+	set := make(map[string]bool)
+	for _, name := range names {
+		set[name] = true
+	}
+	return set
+}
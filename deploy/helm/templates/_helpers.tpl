@@ -0,0 +1,12 @@
+{{/*
+Prefix of names of resources, which must differ between releases to install
+several instances of the driver with different driver names. It's truncated
+to leave space for suffixes like "-provisioner".
+*/}}
+{{- define "csi-s3.fullname" -}}
+{{- if contains .Chart.Name .Release.Name -}}
+{{- .Release.Name | trunc 50 | trimSuffix "-" -}}
+{{- else -}}
+{{- printf "%s-%s" .Release.Name .Chart.Name | trunc 50 | trimSuffix "-" -}}
+{{- end -}}
+{{- end -}}
@@ -3,27 +3,30 @@ module github.com/yandex-cloud/k8s-csi-s3
 go 1.15
 
 require (
-	github.com/container-storage-interface/spec v1.1.0
-	github.com/coreos/go-systemd/v22 v22.5.0 // indirect
-	github.com/godbus/dbus/v5 v5.0.4 // indirect
+	github.com/container-storage-interface/spec v1.8.0
+	github.com/coreos/go-systemd/v22 v22.5.0
+	github.com/godbus/dbus/v5 v5.0.4
 	github.com/golang/glog v0.0.0-20160126235308-23def4e6c14b
-	github.com/golang/protobuf v1.1.0 // indirect
-	github.com/kubernetes-csi/csi-lib-utils v0.6.1 // indirect
-	github.com/kubernetes-csi/csi-test v2.0.0+incompatible
-	github.com/kubernetes-csi/drivers v1.0.2
+	github.com/golang/protobuf v1.5.2
+	github.com/kubernetes-csi/csi-lib-utils v0.6.1
+	github.com/kubernetes-csi/csi-test/v4 v4.3.0
 	github.com/minio/minio-go/v7 v7.0.5
-	github.com/mitchellh/go-ps v0.0.0-20170309133038-4fdf99ab2936
-	github.com/onsi/ginkgo v1.5.0
-	github.com/onsi/gomega v1.4.0
-	github.com/spf13/afero v1.2.1 // indirect
-	golang.org/x/crypto v0.0.0-20200820211705-5c72a883971a // indirect
-	golang.org/x/net v0.0.0-20200707034311-ab3426394381
-	golang.org/x/sync v0.0.0-20190227155943-e225da77a7e6 // indirect
-	golang.org/x/sys v0.0.0-20200922070232-aee5d888a860 // indirect
-	google.golang.org/genproto v0.0.0-20180716172848-2731d4fa720b // indirect
-	google.golang.org/grpc v1.13.0
-	k8s.io/apimachinery v0.0.0-20180714051327-705cfa51a97f // indirect
+	github.com/onsi/ginkgo v1.14.2
+	github.com/onsi/gomega v1.10.4
+	github.com/prometheus/client_golang v1.11.1
+	go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc v0.25.0
+	go.opentelemetry.io/otel v1.0.1
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc v1.0.1
+	go.opentelemetry.io/otel/sdk v1.0.1
+	go.opentelemetry.io/otel/trace v1.0.1
+	go.opentelemetry.io/proto/otlp v0.9.0
+	golang.org/x/net v0.7.0
+	google.golang.org/grpc v1.41.0
+	google.golang.org/protobuf v1.27.1
+	gopkg.in/yaml.v2 v2.4.0
+	k8s.io/api v0.20.15
+	k8s.io/apimachinery v0.20.15
+	k8s.io/client-go v0.20.15
 	k8s.io/klog v0.2.0 // indirect
 	k8s.io/kubernetes v1.13.4
-	k8s.io/utils v0.0.0-20180703210027-ab9069044f32 // indirect
 )
@@ -0,0 +1,191 @@
+package driver
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/logging"
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/mounter"
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/s3"
+)
+
+// Interval of checking the config file for changes
+const configPollInterval = 10 * time.Second
+
+// Format of CSI driver names
+var driverNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9]([-_.a-zA-Z0-9]{0,61}[a-zA-Z0-9])?$`)
+
+// Config holds settings of the YAML config file. Settings missing in the
+// file keep values of command line flags or built-in defaults.
+type Config struct {
+	// CSI driver name and version reported by GetPluginInfo, require a restart
+	DriverName    string `yaml:"driverName"`
+	VendorVersion string `yaml:"vendorVersion"`
+	// Plugin directory on the host, /var/lib/kubelet/plugins/<driverName>
+	// if empty, requires a restart
+	PluginDir string `yaml:"pluginDir"`
+	// Path of the geesefs binary
+	GeesefsPath string `yaml:"geesefsPath"`
+	// Default mount and unmount timeouts of volumes
+	MountTimeout   time.Duration `yaml:"mountTimeout"`
+	UnmountTimeout time.Duration `yaml:"unmountTimeout"`
+	// Number of objects removed in parallel when a volume is deleted
+	DeleteParallelism int `yaml:"deleteParallelism"`
+}
+
+// baseConfig returns settings used when the config file doesn't set them
+func baseConfig(options Options) Config {
+	config := Config{
+		DriverName:        driverName,
+		VendorVersion:     vendorVersion,
+		PluginDir:         os.Getenv("PLUGIN_DIR"),
+		GeesefsPath:       mounter.DefaultSettings.GeesefsPath,
+		MountTimeout:      mounter.DefaultSettings.MountTimeout,
+		UnmountTimeout:    mounter.DefaultSettings.UnmountTimeout,
+		DeleteParallelism: 16,
+	}
+	if options.DriverName != "" {
+		config.DriverName = options.DriverName
+	}
+	if options.MountTimeout > 0 {
+		config.MountTimeout = options.MountTimeout
+	}
+	if options.UnmountTimeout > 0 {
+		config.UnmountTimeout = options.UnmountTimeout
+	}
+	return config
+}
+
+// loadConfig reads the config file over base settings and validates the result
+func loadConfig(path string, base Config) (Config, []byte, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Config{}, nil, fmt.Errorf("Error reading config %s: %v", path, err)
+	}
+	config, err := parseConfig(path, buf, base)
+	return config, buf, err
+}
+
+func parseConfig(path string, buf []byte, base Config) (Config, error) {
+	config := base
+	if err := yaml.UnmarshalStrict(buf, &config); err != nil {
+		return Config{}, fmt.Errorf("Error parsing config %s: %v", path, err)
+	}
+	config.setDefaults()
+	if err := config.validate(); err != nil {
+		return Config{}, fmt.Errorf("Invalid config %s: %v", path, err)
+	}
+	return config, nil
+}
+
+// setDefaults sets settings which depend on other ones
+func (c *Config) setDefaults() {
+	if c.PluginDir == "" {
+		c.PluginDir = "/var/lib/kubelet/plugins/" + c.DriverName
+	}
+}
+
+func (c Config) validate() error {
+	if !driverNameRegexp.MatchString(c.DriverName) {
+		return fmt.Errorf("driverName %q must be at most 63 characters long, consist of alphanumerics, '-', '_' or '.' and begin and end with an alphanumeric", c.DriverName)
+	}
+	if c.VendorVersion == "" {
+		return fmt.Errorf("vendorVersion is empty")
+	}
+	if !filepath.IsAbs(c.PluginDir) {
+		return fmt.Errorf("pluginDir must be an absolute path")
+	}
+	if !filepath.IsAbs(c.GeesefsPath) {
+		return fmt.Errorf("geesefsPath must be an absolute path")
+	}
+	if c.MountTimeout <= 0 {
+		return fmt.Errorf("mountTimeout must be positive")
+	}
+	if c.UnmountTimeout <= 0 {
+		return fmt.Errorf("unmountTimeout must be positive")
+	}
+	if c.DeleteParallelism <= 0 {
+		return fmt.Errorf("deleteParallelism must be positive")
+	}
+	return nil
+}
+
+// apply applies settings which may change at runtime
+func (c Config) apply() {
+	// Units of the default driver keep names without a prefix
+	// so that they are found after an upgrade
+	unitPrefix := ""
+	if c.DriverName != defaultDriverName {
+		unitPrefix = c.DriverName + "-"
+	}
+	mounter.Configure(mounter.Settings{
+		MountTimeout:   c.MountTimeout,
+		UnmountTimeout: c.UnmountTimeout,
+		GeesefsPath:    c.GeesefsPath,
+		PluginDir:      c.PluginDir,
+		UnitPrefix:     unitPrefix,
+	})
+	s3.SetDeleteParallelism(c.DeleteParallelism)
+}
+
+// watchConfig reloads the config file on SIGHUP and when its contents change
+func (s3 *driver) watchConfig() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-hup:
+			s3.reloadConfig(true)
+		case <-ticker.C:
+			s3.reloadConfig(false)
+		}
+	}
+}
+
+// reloadConfig applies the config file if it is forced or the file has
+// changed. Invalid files are ignored, settings which require a restart keep
+// their values.
+func (s3 *driver) reloadConfig(force bool) {
+	path := s3.options.ConfigFile
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		logging.Errorf("Error reading config %s: %v", path, err)
+		return
+	}
+	if !force && bytes.Equal(buf, s3.configData) {
+		return
+	}
+	// An invalid file is reported once until it changes
+	s3.configData = buf
+	config, err := parseConfig(path, buf, baseConfig(s3.options))
+	if err != nil {
+		logging.Errorf("%v, keeping the previous config", err)
+		return
+	}
+	if config.DriverName != s3.config.DriverName {
+		logging.Warningf("Changing driverName requires a restart, keeping %s", s3.config.DriverName)
+		config.DriverName = s3.config.DriverName
+	}
+	if config.VendorVersion != s3.config.VendorVersion {
+		logging.Warningf("Changing vendorVersion requires a restart, keeping %s", s3.config.VendorVersion)
+		config.VendorVersion = s3.config.VendorVersion
+	}
+	if config.PluginDir != s3.config.PluginDir {
+		logging.Warningf("Changing pluginDir requires a restart, keeping %s", s3.config.PluginDir)
+		config.PluginDir = s3.config.PluginDir
+	}
+	config.apply()
+	s3.config = config
+	logging.Infof("Reloaded config %s", path)
+}
@@ -0,0 +1,137 @@
+package driver
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Config", func() {
+	var dir, path string
+	var base Config
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "config")
+		Expect(err).NotTo(HaveOccurred())
+		path = filepath.Join(dir, "config.yaml")
+		base = baseConfig(Options{DriverName: "s3.csi.example.com", MountTimeout: time.Minute})
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	writeConfig := func(content string) {
+		Expect(ioutil.WriteFile(path, []byte(content), 0644)).To(Succeed())
+	}
+
+	It("layers the file over flags and defaults", func() {
+		Expect(base.DriverName).To(Equal("s3.csi.example.com"))
+		Expect(base.MountTimeout).To(Equal(time.Minute))
+
+		writeConfig("unmountTimeout: 45s\ndeleteParallelism: 4\n")
+		config, buf, err := loadConfig(path, base)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(buf)).To(ContainSubstring("unmountTimeout"))
+		Expect(config.DriverName).To(Equal("s3.csi.example.com"))
+		Expect(config.MountTimeout).To(Equal(time.Minute))
+		Expect(config.UnmountTimeout).To(Equal(45 * time.Second))
+		Expect(config.DeleteParallelism).To(Equal(4))
+		Expect(config.GeesefsPath).To(Equal(base.GeesefsPath))
+	})
+
+	It("overrides flags with the file", func() {
+		writeConfig("driverName: other.csi.example.com\nmountTimeout: 2m\n")
+		config, _, err := loadConfig(path, base)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(config.DriverName).To(Equal("other.csi.example.com"))
+		Expect(config.MountTimeout).To(Equal(2 * time.Minute))
+	})
+
+	It("derives the plugin directory from the driver name", func() {
+		base.PluginDir = ""
+		config, err := parseConfig(path, []byte("driverName: other.csi.example.com\n"), base)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(config.PluginDir).To(Equal("/var/lib/kubelet/plugins/other.csi.example.com"))
+	})
+
+	It("fails to load a missing file", func() {
+		_, _, err := loadConfig(path, base)
+		Expect(err).To(MatchError(ContainSubstring("Error reading config")))
+	})
+
+	table.DescribeTable("rejects invalid files",
+		func(content, message string) {
+			_, err := parseConfig(path, []byte(content), base)
+			Expect(err).To(MatchError(ContainSubstring(message)))
+		},
+		table.Entry("unknown setting", "mountTimeot: 1m\n", "field mountTimeot not found"),
+		table.Entry("wrong type", "deleteParallelism: many\n", "Error parsing config"),
+		table.Entry("invalid YAML", "driverName: [\n", "Error parsing config"),
+		table.Entry("driver name", "driverName: -bad\n", "driverName"),
+		table.Entry("empty vendor version", "vendorVersion: \"\"\n", "vendorVersion is empty"),
+		table.Entry("relative plugin dir", "pluginDir: plugins\n", "pluginDir must be an absolute path"),
+		table.Entry("relative geesefs path", "geesefsPath: geesefs\n", "geesefsPath must be an absolute path"),
+		table.Entry("zero mount timeout", "mountTimeout: 0s\n", "mountTimeout must be positive"),
+		table.Entry("negative unmount timeout", "unmountTimeout: -1s\n", "unmountTimeout must be positive"),
+		table.Entry("zero parallelism", "deleteParallelism: 0\n", "deleteParallelism must be positive"),
+	)
+
+	Describe("reloadConfig", func() {
+		var d *driver
+
+		BeforeEach(func() {
+			writeConfig("mountTimeout: 30s\n")
+			config, data, err := loadConfig(path, base)
+			Expect(err).NotTo(HaveOccurred())
+			d = &driver{
+				options:    Options{ConfigFile: path, DriverName: base.DriverName, MountTimeout: time.Minute},
+				config:     config,
+				configData: data,
+			}
+		})
+
+		AfterEach(func() {
+			config := baseConfig(Options{})
+			config.setDefaults()
+			config.apply()
+		})
+
+		It("applies changed settings", func() {
+			writeConfig("mountTimeout: 40s\n")
+			d.reloadConfig(false)
+			Expect(d.config.MountTimeout).To(Equal(40 * time.Second))
+		})
+
+		It("ignores unchanged files unless forced", func() {
+			d.config.MountTimeout = time.Second
+			d.reloadConfig(false)
+			Expect(d.config.MountTimeout).To(Equal(time.Second))
+			d.reloadConfig(true)
+			Expect(d.config.MountTimeout).To(Equal(30 * time.Second))
+		})
+
+		It("keeps settings which require a restart", func() {
+			previous := d.config
+			writeConfig("driverName: other.csi.example.com\nvendorVersion: v99\npluginDir: /other\nmountTimeout: 40s\n")
+			d.reloadConfig(false)
+			Expect(d.config.DriverName).To(Equal(previous.DriverName))
+			Expect(d.config.VendorVersion).To(Equal(previous.VendorVersion))
+			Expect(d.config.PluginDir).To(Equal(previous.PluginDir))
+			Expect(d.config.MountTimeout).To(Equal(40 * time.Second))
+		})
+
+		It("keeps the previous config if the file is invalid", func() {
+			previous := d.config
+			writeConfig("mountTimeout: 0s\n")
+			d.reloadConfig(false)
+			Expect(d.config).To(Equal(previous))
+		})
+	})
+})
@@ -23,23 +23,51 @@ import (
 	"io"
 	"path"
 	"strings"
+	"time"
 
+	"github.com/kubernetes-csi/csi-lib-utils/protosanitizer"
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/logging"
 	"github.com/yandex-cloud/k8s-csi-s3/pkg/mounter"
 	"github.com/yandex-cloud/k8s-csi-s3/pkg/s3"
-	"github.com/golang/glog"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
-	csicommon "github.com/kubernetes-csi/drivers/pkg/csi-common"
 )
 
+// Capabilities of the controller service
+var controllerCapabilities = []csi.ControllerServiceCapability_RPC_Type{
+	csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+	csi.ControllerServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER,
+}
+
+// Capabilities of the controller service when listing of volumes is enabled
+var listCapabilities = []csi.ControllerServiceCapability_RPC_Type{
+	csi.ControllerServiceCapability_RPC_LIST_VOLUMES,
+	csi.ControllerServiceCapability_RPC_LIST_VOLUMES_PUBLISHED_NODES,
+	csi.ControllerServiceCapability_RPC_VOLUME_CONDITION,
+}
+
+// Time to write the progress of an interrupted deletion
+const deletionProgressTimeout = 10 * time.Second
+
 type controllerServer struct {
-	*csicommon.DefaultControllerServer
+	events *eventRecorder
+	// Cancelled when running RPCs don't finish in time on shutdown
+	abortCtx context.Context
+	// Serializes creation and deletion by volume ID
+	locks *operationLocks
+	// Directory with a mounted S3 secret used by ListVolumes, listing is disabled if empty
+	listSecretDir string
 }
 
-func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
+func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (resp *csi.CreateVolumeResponse, err error) {
+	defer func() {
+		if err != nil {
+			cs.events.provisioningFailed(req.GetParameters(), err)
+		}
+	}()
 	params := req.GetParameters()
 	capacityBytes := int64(req.GetCapacityRange().GetRequiredBytes())
 	volumeID := sanitizeVolumeID(req.GetName())
@@ -52,11 +80,7 @@ func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVol
 		prefix = volumeID
 		volumeID = path.Join(bucketName, prefix)
 	}
-
-	if err := cs.Driver.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME); err != nil {
-		glog.V(3).Infof("invalid create volume req: %v", req)
-		return nil, err
-	}
+	ctx = logging.NewContext(ctx, logging.FromContext(ctx).With("volume_id", volumeID))
 
 	// Check arguments
 	if len(volumeID) == 0 {
@@ -65,37 +89,70 @@ func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVol
 	if req.GetVolumeCapabilities() == nil {
 		return nil, status.Error(codes.InvalidArgument, "Volume Capabilities missing in request")
 	}
-
-	glog.V(4).Infof("Got a request to create volume %s", volumeID)
-
+	if err := validateVolumeCapabilities(req.GetVolumeCapabilities()); err != nil {
+		logging.FromContext(ctx).V(3).Infof("Invalid create volume req: %v", protosanitizer.StripSecrets(req))
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
 	client, err := s3.NewClientFromSecret(req.GetSecrets())
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize S3 client: %s", err)
 	}
+	// Nodes select the mounter with the config from the same secrets
+	if err := mounter.ValidateParams(params, client.Config); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	unlock, err := cs.locks.lock(volumeID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	logging.FromContext(ctx).V(4).Infof("Got a request to create volume %s", volumeID)
+
+	client = client.WithContext(ctx).WithAbort(cs.abortCtx)
 
 	exists, err := client.BucketExists(bucketName)
 	if err != nil {
-		return nil, fmt.Errorf("failed to check if bucket %s exists: %v", volumeID, err)
+		return nil, fmt.Errorf("failed to check if bucket %s exists: %w", volumeID, err)
 	}
 
 	if !exists {
 		if err = client.CreateBucket(bucketName); err != nil {
-			return nil, fmt.Errorf("failed to create bucket %s: %v", bucketName, err)
+			return nil, fmt.Errorf("failed to create bucket %s: %w", bucketName, err)
+		}
+	} else {
+		// The volume may already exist, it must have the same capacity then
+		meta, _, err := client.ReadVolumeMeta(bucketName, prefix)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read metadata of volume %s: %w", volumeID, err)
+		}
+		if meta != nil && meta.CapacityBytes != capacityBytes {
+			return nil, status.Errorf(codes.AlreadyExists, "Volume %s already exists with capacity %v", volumeID, meta.CapacityBytes)
 		}
 	}
 
 	if err = client.CreatePrefix(bucketName, prefix); err != nil {
-		return nil, fmt.Errorf("failed to create prefix %s: %v", prefix, err)
+		return nil, fmt.Errorf("failed to create prefix %s: %w", prefix, err)
 	}
 
-	glog.V(4).Infof("create volume %s", volumeID)
-	// DeleteVolume lacks VolumeContext, but publish&unpublish requests have it,
-	// so we don't need to store additional metadata anywhere
+	// DeleteVolume lacks VolumeContext, but publish&unpublish requests have it.
+	// The metadata object is only read by ListVolumes.
 	context := make(map[string]string)
 	for k, v := range params {
 		context[k] = v
 	}
-	context["capacity"] = fmt.Sprintf("%v", capacityBytes)
+	context[mounter.CapacityKey] = fmt.Sprintf("%v", capacityBytes)
+	err = client.WriteVolumeMeta(bucketName, prefix, &s3.VolumeMeta{
+		Driver:        driverName,
+		VolumeID:      volumeID,
+		CapacityBytes: capacityBytes,
+		VolumeContext: context,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to write metadata of volume %s: %w", volumeID, err)
+	}
+
+	logging.FromContext(ctx).V(4).Infof("create volume %s", volumeID)
 	return &csi.CreateVolumeResponse{
 		Volume: &csi.Volume{
 			VolumeId:      volumeID,
@@ -113,39 +170,82 @@ func (cs *controllerServer) DeleteVolume(ctx context.Context, req *csi.DeleteVol
 	if len(volumeID) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "Volume ID missing in request")
 	}
-
-	if err := cs.Driver.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME); err != nil {
-		glog.V(3).Infof("Invalid delete volume req: %v", req)
+	unlock, err := cs.locks.lock(volumeID)
+	if err != nil {
 		return nil, err
 	}
-	glog.V(4).Infof("Deleting volume %s", volumeID)
+	defer unlock()
+	logging.FromContext(ctx).V(4).Infof("Deleting volume %s", volumeID)
 
 	client, err := s3.NewClientFromSecret(req.GetSecrets())
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize S3 client: %s", err)
 	}
+	client = client.WithContext(ctx)
+	aborted := client.WithAbort(cs.abortCtx)
+
+	// The progress is kept in the bucket, so that a deletion interrupted by
+	// a shutdown is known to the retry. It's removed with the volume.
+	progress, err := aborted.ReadDeletionProgress(bucketName, prefix)
+	if err != nil {
+		return nil, cs.deletionFailed(volumeID, 0, fmt.Errorf("failed to read deletion progress of volume %s: %w", volumeID, err))
+	}
+	if progress != nil {
+		logging.FromContext(ctx).Infof("Continuing deletion of volume %s started at %v, %v objects (%v bytes) are removed",
+			volumeID, progress.Started, progress.RemovedObjects, progress.RemovedBytes)
+	} else {
+		progress = &s3.DeletionProgress{Started: time.Now()}
+		err = aborted.WriteDeletionProgress(bucketName, prefix, progress)
+		if s3.ErrorCode(err) == "NoSuchBucket" {
+			logging.FromContext(ctx).V(4).Infof("Bucket %s doesn't exist, volume %s is deleted already", bucketName, volumeID)
+			return &csi.DeleteVolumeResponse{}, nil
+		}
+		if err != nil {
+			return nil, cs.deletionFailed(volumeID, 0, fmt.Errorf("failed to write deletion progress of volume %s: %w", volumeID, err))
+		}
+	}
+	remover := aborted.WithDeletionProgress(bucketName, prefix, progress)
 
 	var deleteErr error
 	if prefix == "" {
 		// prefix is empty, we delete the whole bucket
-		if err := client.RemoveBucket(bucketName); err != nil && err.Error() != "The specified bucket does not exist" {
+		if err := remover.RemoveBucket(bucketName); err != nil && err.Error() != "The specified bucket does not exist" {
 			deleteErr = err
 		}
-		glog.V(4).Infof("Bucket %s removed", bucketName)
+		logging.FromContext(ctx).V(4).Infof("Bucket %s removed", bucketName)
 	} else {
-		if err := client.RemovePrefix(bucketName, prefix); err != nil {
+		if err := remover.RemovePrefix(bucketName, prefix); err != nil {
 			deleteErr = fmt.Errorf("unable to remove prefix: %w", err)
+		} else if err := remover.RemoveVolumeMeta(bucketName, prefix); err != nil {
+			deleteErr = fmt.Errorf("unable to remove metadata: %w", err)
 		}
-		glog.V(4).Infof("Prefix %s removed", prefix)
+		logging.FromContext(ctx).V(4).Infof("Prefix %s removed", prefix)
 	}
 
 	if deleteErr != nil {
-		return nil, deleteErr
+		// The client is aborted already, the progress is written with a timeout
+		timeout, cancel := context.WithTimeout(context.Background(), deletionProgressTimeout)
+		defer cancel()
+		if err := client.WithAbort(timeout).WriteDeletionProgress(bucketName, prefix, progress); err != nil {
+			logging.FromContext(ctx).Warningf("Error writing deletion progress of volume %s: %v", volumeID, err)
+		}
+		return nil, cs.deletionFailed(volumeID, progress.RemovedObjects, deleteErr)
 	}
 
 	return &csi.DeleteVolumeResponse{}, nil
 }
 
+// deletionFailed returns the error of a failed deletion, which is Aborted
+// if the deletion was interrupted by shutdown
+func (cs *controllerServer) deletionFailed(volumeID string, removedObjects int64, err error) error {
+	if cs.abortCtx.Err() != nil {
+		// Removed objects stay removed, the retry continues the removal
+		return status.Errorf(codes.Aborted, "Deletion of volume %s was interrupted by shutdown after %v objects: %v",
+			volumeID, removedObjects, err)
+	}
+	return err
+}
+
 func (cs *controllerServer) ValidateVolumeCapabilities(ctx context.Context, req *csi.ValidateVolumeCapabilitiesRequest) (*csi.ValidateVolumeCapabilitiesResponse, error) {
 	// Check arguments
 	if len(req.GetVolumeId()) == 0 {
@@ -160,6 +260,7 @@ func (cs *controllerServer) ValidateVolumeCapabilities(ctx context.Context, req
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize S3 client: %s", err)
 	}
+	client = client.WithContext(ctx).WithAbort(cs.abortCtx)
 	exists, err := client.BucketExists(bucketName)
 	if err != nil {
 		return nil, err
@@ -170,31 +271,167 @@ func (cs *controllerServer) ValidateVolumeCapabilities(ctx context.Context, req
 		return nil, status.Error(codes.NotFound, fmt.Sprintf("bucket of volume with id %s does not exist", req.GetVolumeId()))
 	}
 
-	supportedAccessMode := &csi.VolumeCapability_AccessMode{
-		Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+	if err := validateVolumeCapabilities(req.GetVolumeCapabilities()); err != nil {
+		return &csi.ValidateVolumeCapabilitiesResponse{Message: err.Error()}, nil
 	}
 
-	for _, capability := range req.VolumeCapabilities {
-		if capability.GetAccessMode().GetMode() != supportedAccessMode.GetMode() {
-			return &csi.ValidateVolumeCapabilitiesResponse{Message: "Only single node writer is supported"}, nil
+	return &csi.ValidateVolumeCapabilitiesResponse{
+		Confirmed: &csi.ValidateVolumeCapabilitiesResponse_Confirmed{
+			VolumeContext:      req.GetVolumeContext(),
+			VolumeCapabilities: req.GetVolumeCapabilities(),
+			Parameters:         req.GetParameters(),
+		},
+	}, nil
+}
+
+// validateVolumeCapabilities checks that volumes may be used with capabilities.
+// Volumes are filesystems shared by any number of nodes, so all access modes
+// are supported, but block access isn't.
+func validateVolumeCapabilities(capabilities []*csi.VolumeCapability) error {
+	for _, capability := range capabilities {
+		if capability.GetBlock() != nil {
+			return fmt.Errorf("Block access type is not supported")
+		}
+		mode := capability.GetAccessMode().GetMode()
+		if _, ok := csi.VolumeCapability_AccessMode_Mode_name[int32(mode)]; !ok || mode == csi.VolumeCapability_AccessMode_UNKNOWN {
+			return fmt.Errorf("Access mode %v is not supported", mode)
 		}
 	}
+	return nil
+}
 
-	return &csi.ValidateVolumeCapabilitiesResponse{
-		Confirmed: &csi.ValidateVolumeCapabilitiesResponse_Confirmed{
-			VolumeCapabilities: []*csi.VolumeCapability{
-				{
-					AccessMode: supportedAccessMode,
+// ControllerGetCapabilities returns the supported capabilities of the controller server
+func (cs *controllerServer) ControllerGetCapabilities(ctx context.Context, req *csi.ControllerGetCapabilitiesRequest) (*csi.ControllerGetCapabilitiesResponse, error) {
+	var types []csi.ControllerServiceCapability_RPC_Type
+	types = append(types, controllerCapabilities...)
+	if cs.listSecretDir != "" {
+		types = append(types, listCapabilities...)
+	}
+	var caps []*csi.ControllerServiceCapability
+	for _, c := range types {
+		caps = append(caps, &csi.ControllerServiceCapability{
+			Type: &csi.ControllerServiceCapability_Rpc{
+				Rpc: &csi.ControllerServiceCapability_RPC{
+					Type: c,
 				},
 			},
-		},
+		})
+	}
+
+	return &csi.ControllerGetCapabilitiesResponse{
+		Capabilities: caps,
 	}, nil
 }
 
+func (cs *controllerServer) ControllerPublishVolume(ctx context.Context, req *csi.ControllerPublishVolumeRequest) (*csi.ControllerPublishVolumeResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "ControllerPublishVolume is not implemented")
+}
+
+func (cs *controllerServer) ControllerUnpublishVolume(ctx context.Context, req *csi.ControllerUnpublishVolumeRequest) (*csi.ControllerUnpublishVolumeResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "ControllerUnpublishVolume is not implemented")
+}
+
+// ListVolumes lists volumes with metadata objects written by CreateVolume in
+// buckets of the listing secret, sorted by volume ID. The starting token is the
+// ID of the last volume of the previous page, listing continues after it.
+func (cs *controllerServer) ListVolumes(ctx context.Context, req *csi.ListVolumesRequest) (*csi.ListVolumesResponse, error) {
+	if cs.listSecretDir == "" {
+		return nil, status.Error(codes.Unimplemented, "ListVolumes is disabled, it requires --list-volumes-secret-dir")
+	}
+	if req.GetMaxEntries() < 0 {
+		return nil, status.Error(codes.InvalidArgument, "Max entries must not be negative")
+	}
+	// The token is the ID of the last returned volume, so that pages don't
+	// shift when volumes are created or deleted between calls
+	afterBucket, afterPrefix := "", ""
+	if token := req.GetStartingToken(); token != "" {
+		afterBucket, afterPrefix = volumeIDToBucketPrefix(token)
+		if afterBucket == "" || strings.HasSuffix(token, "/") {
+			return nil, status.Errorf(codes.Aborted, "Invalid starting token %q", token)
+		}
+	}
+
+	secret, err := readSecretDir(cs.listSecretDir)
+	if err != nil {
+		return nil, err
+	}
+	client, err := s3.NewClientFromSecret(secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize S3 client: %s", err)
+	}
+	client = client.WithContext(ctx).WithAbort(cs.abortCtx)
+
+	volumes, more, err := client.ListVolumes(driverName, afterBucket, afterPrefix, int(req.GetMaxEntries()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list volumes: %w", err)
+	}
+	resp := &csi.ListVolumesResponse{}
+	for _, volume := range volumes {
+		volumeID := path.Join(volume.BucketName, volume.Prefix)
+		resp.Entries = append(resp.Entries, listEntry(volumeID, volume.Meta, volume.Nodes))
+	}
+	if more && len(resp.Entries) > 0 {
+		resp.NextToken = resp.Entries[len(resp.Entries)-1].Volume.VolumeId
+	}
+	return resp, nil
+}
+
+// listEntry returns the ListVolumes entry of a volume. The volume is abnormal
+// if one of the nodes which have staged it reported an error. Statuses which
+// nodes didn't refresh in time are ignored.
+func listEntry(volumeID string, meta *s3.VolumeMeta, nodes []s3.NodeStatus) *csi.ListVolumesResponse_Entry {
+	condition := &csi.VolumeCondition{Message: "No errors reported by nodes"}
+	var nodeIDs []string
+	var errors []string
+	for _, node := range nodes {
+		if time.Since(node.Time) > nodeStatusExpiry {
+			continue
+		}
+		nodeIDs = append(nodeIDs, node.NodeID)
+		if node.Abnormal {
+			errors = append(errors, fmt.Sprintf("%s: %s", node.NodeID, node.Message))
+		}
+	}
+	if len(errors) > 0 {
+		condition = &csi.VolumeCondition{Abnormal: true, Message: strings.Join(errors, "; ")}
+	}
+	return &csi.ListVolumesResponse_Entry{
+		Volume: &csi.Volume{
+			VolumeId:      volumeID,
+			CapacityBytes: meta.CapacityBytes,
+			VolumeContext: meta.VolumeContext,
+		},
+		Status: &csi.ListVolumesResponse_VolumeStatus{
+			PublishedNodeIds: nodeIDs,
+			VolumeCondition:  condition,
+		},
+	}
+}
+
+func (cs *controllerServer) GetCapacity(ctx context.Context, req *csi.GetCapacityRequest) (*csi.GetCapacityResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "GetCapacity is not implemented")
+}
+
+func (cs *controllerServer) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "CreateSnapshot is not implemented")
+}
+
+func (cs *controllerServer) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequest) (*csi.DeleteSnapshotResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "DeleteSnapshot is not implemented")
+}
+
+func (cs *controllerServer) ListSnapshots(ctx context.Context, req *csi.ListSnapshotsRequest) (*csi.ListSnapshotsResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "ListSnapshots is not implemented")
+}
+
 func (cs *controllerServer) ControllerExpandVolume(ctx context.Context, req *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
 	return &csi.ControllerExpandVolumeResponse{}, status.Error(codes.Unimplemented, "ControllerExpandVolume is not implemented")
 }
 
+func (cs *controllerServer) ControllerGetVolume(ctx context.Context, req *csi.ControllerGetVolumeRequest) (*csi.ControllerGetVolumeResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "ControllerGetVolume is not implemented")
+}
+
 func sanitizeVolumeID(volumeID string) string {
 	volumeID = strings.ToLower(volumeID)
 	if len(volumeID) > 63 {
@@ -0,0 +1,278 @@
+package driver
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gopkg.in/yaml.v2"
+
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/s3"
+)
+
+// testSecrets returns credentials of the S3 server used by tests
+func testSecrets() map[string]string {
+	buf, err := ioutil.ReadFile("../../test/secret.yaml")
+	Expect(err).NotTo(HaveOccurred())
+	var secrets map[string]map[string]string
+	Expect(yaml.Unmarshal(buf, &secrets)).To(Succeed())
+	return secrets["CreateVolumeSecret"]
+}
+
+var mountCapabilities = []*csi.VolumeCapability{{
+	AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+	AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+}}
+
+func newTestControllerServer() *controllerServer {
+	return &controllerServer{
+		abortCtx: context.Background(),
+		locks:    newOperationLocks(),
+	}
+}
+
+var _ = Describe("CreateVolume", func() {
+	var cs *controllerServer
+
+	BeforeEach(func() {
+		cs = newTestControllerServer()
+	})
+
+	create := func(name string, params map[string]string, capacity int64) error {
+		_, err := cs.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+			Name:               name,
+			CapacityRange:      &csi.CapacityRange{RequiredBytes: capacity},
+			VolumeCapabilities: mountCapabilities,
+			Parameters:         params,
+			Secrets:            testSecrets(),
+		})
+		return err
+	}
+
+	for _, params := range []map[string]string{nil, {"bucket": "test-create-shared"}} {
+		params := params
+		It("is idempotent and rejects another capacity "+params["bucket"], func() {
+			name := "test-create-capacity"
+			Expect(create(name, params, 1<<30)).To(Succeed())
+			Expect(create(name, params, 1<<30)).To(Succeed())
+			err := create(name, params, 2<<30)
+			Expect(status.Code(err)).To(Equal(codes.AlreadyExists))
+
+			volumeID := path.Join(params["bucket"], name)
+			_, err = cs.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: volumeID, Secrets: testSecrets()})
+			Expect(err).NotTo(HaveOccurred())
+		})
+	}
+
+	It("rejects unknown mounters", func() {
+		err := create("test-create-unknown", map[string]string{"mounter": "unknown"}, 1<<30)
+		Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+		Expect(status.Convert(err).Message()).To(Equal(`unknown mounter "unknown"`))
+	})
+})
+
+var _ = Describe("listEntry", func() {
+	meta := &s3.VolumeMeta{
+		VolumeID:      "bucket/pvc-1",
+		CapacityBytes: 1 << 30,
+		VolumeContext: map[string]string{"mounter": "geesefs"},
+	}
+
+	It("returns a healthy volume without nodes", func() {
+		entry := listEntry("bucket/pvc-1", meta, nil)
+		Expect(entry.Volume.VolumeId).To(Equal("bucket/pvc-1"))
+		Expect(entry.Volume.CapacityBytes).To(Equal(int64(1 << 30)))
+		Expect(entry.Volume.VolumeContext).To(Equal(meta.VolumeContext))
+		Expect(entry.Status.PublishedNodeIds).To(BeEmpty())
+		Expect(entry.Status.VolumeCondition.Abnormal).To(BeFalse())
+	})
+
+	It("reports nodes and errors of nodes", func() {
+		now := time.Now()
+		entry := listEntry("bucket/pvc-1", meta, []s3.NodeStatus{
+			{NodeID: "node-1", Message: "Volume is mounted", Time: now},
+			{NodeID: "node-2", Abnormal: true, Message: "mount failed", Time: now},
+			{NodeID: "node-3", Abnormal: true, Message: "timeout", Time: now},
+		})
+		Expect(entry.Status.PublishedNodeIds).To(Equal([]string{"node-1", "node-2", "node-3"}))
+		Expect(entry.Status.VolumeCondition.Abnormal).To(BeTrue())
+		Expect(entry.Status.VolumeCondition.Message).To(Equal("node-2: mount failed; node-3: timeout"))
+	})
+
+	It("ignores expired statuses", func() {
+		expired := time.Now().Add(-nodeStatusExpiry - time.Minute)
+		entry := listEntry("bucket/pvc-1", meta, []s3.NodeStatus{
+			{NodeID: "node-1", Message: "Volume is mounted", Time: time.Now()},
+			{NodeID: "node-2", Abnormal: true, Message: "mount failed", Time: expired},
+		})
+		Expect(entry.Status.PublishedNodeIds).To(Equal([]string{"node-1"}))
+		Expect(entry.Status.VolumeCondition.Abnormal).To(BeFalse())
+	})
+})
+
+var _ = Describe("ListVolumes", func() {
+	const bucket = "test-list-volumes"
+	var cs *controllerServer
+
+	BeforeEach(func() {
+		cs = newTestControllerServer()
+		dir, err := ioutil.TempDir("", "list-secret")
+		Expect(err).NotTo(HaveOccurred())
+		for key, value := range testSecrets() {
+			Expect(ioutil.WriteFile(path.Join(dir, key), []byte(value), 0600)).To(Succeed())
+		}
+		cs.listSecretDir = dir
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(cs.listSecretDir)).To(Succeed())
+	})
+
+	create := func(name string) {
+		_, err := cs.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+			Name:               name,
+			CapacityRange:      &csi.CapacityRange{RequiredBytes: 1 << 30},
+			VolumeCapabilities: mountCapabilities,
+			Parameters:         map[string]string{"bucket": bucket},
+			Secrets:            testSecrets(),
+		})
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	remove := func(name string) {
+		_, err := cs.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{
+			VolumeId: path.Join(bucket, name),
+			Secrets:  testSecrets(),
+		})
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	// list returns IDs of test volumes from a page and the next token
+	list := func(token string, maxEntries int32) ([]string, string) {
+		resp, err := cs.ListVolumes(context.Background(), &csi.ListVolumesRequest{
+			StartingToken: token,
+			MaxEntries:    maxEntries,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		var ids []string
+		for _, entry := range resp.Entries {
+			if strings.HasPrefix(entry.Volume.VolumeId, bucket+"/") {
+				ids = append(ids, entry.Volume.VolumeId)
+			}
+		}
+		return ids, resp.NextToken
+	}
+
+	It("returns stable pages", func() {
+		for _, name := range []string{"pvc-1", "pvc-3", "pvc-5"} {
+			create(name)
+			defer remove(name)
+		}
+
+		ids, token := list(bucket, 2)
+		Expect(ids).To(Equal([]string{bucket + "/pvc-1", bucket + "/pvc-3"}))
+		Expect(token).To(Equal(bucket + "/pvc-3"))
+
+		// Volumes created before the token don't shift the next page
+		create("pvc-2")
+		defer remove("pvc-2")
+		ids, _ = list(token, 1)
+		Expect(ids).To(Equal([]string{bucket + "/pvc-5"}))
+
+		// Deleting the last returned volume doesn't invalidate the token
+		remove("pvc-3")
+		create("pvc-3")
+		ids, _ = list(token, 1)
+		Expect(ids).To(Equal([]string{bucket + "/pvc-5"}))
+	})
+
+	It("rejects invalid tokens", func() {
+		for _, token := range []string{"/pvc-1", bucket + "/"} {
+			_, err := cs.ListVolumes(context.Background(), &csi.ListVolumesRequest{StartingToken: token})
+			Expect(status.Code(err)).To(Equal(codes.Aborted))
+		}
+	})
+
+	It("rejects negative max entries", func() {
+		_, err := cs.ListVolumes(context.Background(), &csi.ListVolumesRequest{MaxEntries: -1})
+		Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+	})
+})
+
+var _ = Describe("DeleteVolume", func() {
+	const bucket = "test-delete"
+	var cs *controllerServer
+
+	BeforeEach(func() {
+		cs = newTestControllerServer()
+		_, err := cs.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+			Name:               "pvc-1",
+			CapacityRange:      &csi.CapacityRange{RequiredBytes: 1 << 30},
+			VolumeCapabilities: mountCapabilities,
+			Parameters:         map[string]string{"bucket": bucket},
+			Secrets:            testSecrets(),
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	deleteVolume := func() error {
+		_, err := cs.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{
+			VolumeId: bucket + "/pvc-1",
+			Secrets:  testSecrets(),
+		})
+		return err
+	}
+
+	readMeta := func() (*s3.VolumeMeta, *s3.DeletionProgress) {
+		client, err := s3.NewClientFromSecret(testSecrets())
+		Expect(err).NotTo(HaveOccurred())
+		meta, _, err := client.ReadVolumeMeta(bucket, "pvc-1")
+		Expect(err).NotTo(HaveOccurred())
+		progress, err := client.ReadDeletionProgress(bucket, "pvc-1")
+		Expect(err).NotTo(HaveOccurred())
+		return meta, progress
+	}
+
+	It("continues an interrupted deletion", func() {
+		client, err := s3.NewClientFromSecret(testSecrets())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(client.WriteDeletionProgress(bucket, "pvc-1", &s3.DeletionProgress{RemovedObjects: 3})).To(Succeed())
+
+		Expect(deleteVolume()).To(Succeed())
+		meta, progress := readMeta()
+		Expect(meta).To(BeNil())
+		Expect(progress).To(BeNil())
+	})
+
+	It("returns Aborted when interrupted by shutdown", func() {
+		abort, cancel := context.WithCancel(context.Background())
+		cancel()
+		cs.abortCtx = abort
+		Expect(status.Code(deleteVolume())).To(Equal(codes.Aborted))
+		meta, _ := readMeta()
+		Expect(meta).NotTo(BeNil())
+
+		cs.abortCtx = context.Background()
+		Expect(deleteVolume()).To(Succeed())
+		meta, progress := readMeta()
+		Expect(meta).To(BeNil())
+		Expect(progress).To(BeNil())
+	})
+
+	It("succeeds if the bucket doesn't exist", func() {
+		Expect(deleteVolume()).To(Succeed())
+		_, err := cs.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{
+			VolumeId: "test-delete-missing/pvc-1",
+			Secrets:  testSecrets(),
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+})
@@ -17,72 +17,296 @@ limitations under the License.
 package driver
 
 import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
 	"github.com/container-storage-interface/spec/lib/go/csi"
-	"github.com/golang/glog"
 
-	csicommon "github.com/kubernetes-csi/drivers/pkg/csi-common"
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/logging"
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/metrics"
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/mounter"
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/tracing"
 )
 
 type driver struct {
-	driver   *csicommon.CSIDriver
+	nodeID   string
 	endpoint string
+	options  Options
+	events   *eventRecorder
+	// Current settings and contents of the config file
+	config     Config
+	configData []byte
+	// Sends pending trace spans
+	stopTracing func()
+	// Cancelled to abort long operations when shutdown times out
+	abortCtx context.Context
+	abort    context.CancelFunc
 
 	ids *identityServer
 	ns  *nodeServer
 	cs  *controllerServer
 }
 
+const (
+	defaultDriverName = "ru.yandex.s3.csi"
+	// Used if Options.ShutdownTimeout is zero
+	defaultShutdownTimeout = 25 * time.Second
+)
+
 var (
 	vendorVersion = "v1.34.7"
-	driverName    = "ru.yandex.s3.csi"
+	driverName    = defaultDriverName
+)
+
+// Set at build time with -ldflags "-X github.com/yandex-cloud/k8s-csi-s3/pkg/driver.version=..."
+var (
+	version string
+	commit  string
+)
+
+func init() {
+	if version != "" {
+		vendorVersion = version
+	}
+}
+
+// Version returns the version and the commit of the build
+func Version() (string, string) {
+	return vendorVersion, commit
+}
+
+// Modes select which CSI services the driver serves
+const (
+	// ModeController serves identity and controller services for the provisioner
+	ModeController = "controller"
+	// ModeNode serves identity and node services for the node plugin
+	ModeNode = "node"
+	// ModeAll serves all services
+	ModeAll = "all"
 )
 
+// Options holds optional driver settings
+type Options struct {
+	// One of ModeController, ModeNode or ModeAll, all services are served if empty
+	Mode string
+	// CSI driver name, the default one is used if empty
+	DriverName string
+	// YAML config file which is reloaded on changes, see Config
+	ConfigFile string
+	// Node directory for per-volume disk caches, disk cache is disabled if empty
+	CacheDir string
+	// YAML file with exec mounters for external FUSE filesystems
+	MountersConfig string
+	// Default mount and unmount timeouts of volumes, built-in defaults are used if zero
+	MountTimeout   time.Duration
+	UnmountTimeout time.Duration
+	// Address of the HTTP listener serving Prometheus metrics, disabled if empty
+	MetricsAddress string
+	// Address of the HTTP listener serving /healthz and /readyz, disabled if empty
+	HealthAddress string
+	// Checks run by readiness probes and the Probe call
+	HealthCheckMounters bool
+	HealthCheckSystemd  bool
+	// Directory with a mounted S3 secret to check S3 access, disabled if empty
+	HealthS3SecretDir string
+	// Directory with a mounted S3 secret whose buckets are searched for
+	// volumes by ListVolumes, listing is disabled if empty
+	ListVolumesSecretDir string
+	// Write statuses of staged volumes to their buckets, which are reported
+	// by ListVolumes as published nodes and volume conditions
+	ReportVolumeStatus bool
+	// Record Kubernetes Events about volume failures
+	KubeEvents bool
+	// Address of the OTLP gRPC collector receiving traces, tracing is disabled if empty
+	OTLPEndpoint string
+	// Time to wait for running RPCs on SIGTERM or SIGINT, they are aborted after it.
+	// A default is used if zero.
+	ShutdownTimeout time.Duration
+}
+
+func (o Options) controller() bool {
+	return o.Mode != ModeNode
+}
+
+func (o Options) node() bool {
+	return o.Mode != ModeController
+}
+
 // New initializes the driver
-func New(nodeID string, endpoint string) (*driver, error) {
-	d := csicommon.NewCSIDriver(driverName, vendorVersion, nodeID)
-	if d == nil {
-		glog.Fatalln("Failed to initialize CSI Driver.")
+func New(nodeID string, endpoint string, options Options) (*driver, error) {
+	switch options.Mode {
+	case "", ModeController, ModeNode, ModeAll:
+	default:
+		return nil, fmt.Errorf("Unknown mode %q, must be %s, %s or %s", options.Mode, ModeController, ModeNode, ModeAll)
+	}
+	config := baseConfig(options)
+	var configData []byte
+	var err error
+	if options.ConfigFile != "" {
+		config, configData, err = loadConfig(options.ConfigFile, config)
+	} else {
+		config.setDefaults()
+		err = config.validate()
+	}
+	if err != nil {
+		return nil, err
+	}
+	driverName = config.DriverName
+	vendorVersion = config.VendorVersion
+	config.apply()
+
+	if options.MountersConfig != "" {
+		if err := mounter.LoadExecMounters(options.MountersConfig); err != nil {
+			return nil, err
+		}
+	}
+
+	stopTracing, err := tracing.Init(options.OTLPEndpoint, driverName, nodeID)
+	if err != nil {
+		return nil, err
 	}
 
+	abortCtx, abort := context.WithCancel(context.Background())
 	s3Driver := &driver{
-		endpoint: endpoint,
-		driver:   d,
+		abortCtx:    abortCtx,
+		abort:       abort,
+		endpoint:    endpoint,
+		nodeID:      nodeID,
+		options:     options,
+		config:      config,
+		configData:  configData,
+		stopTracing: stopTracing,
+	}
+	if options.KubeEvents {
+		events, err := newEventRecorder(nodeID)
+		if err != nil {
+			return nil, err
+		}
+		s3Driver.events = events
 	}
 	return s3Driver, nil
 }
 
-func (s3 *driver) newIdentityServer(d *csicommon.CSIDriver) *identityServer {
+func (s3 *driver) newIdentityServer() *identityServer {
 	return &identityServer{
-		DefaultIdentityServer: csicommon.NewDefaultIdentityServer(d),
+		health:     s3.newHealthChecker(),
+		controller: s3.options.controller(),
+	}
+}
+
+func (s3 *driver) newHealthChecker() *healthChecker {
+	return &healthChecker{
+		endpoint: s3.endpoint,
+		options:  s3.options,
 	}
 }
 
-func (s3 *driver) newControllerServer(d *csicommon.CSIDriver) *controllerServer {
+func (s3 *driver) newControllerServer() *controllerServer {
 	return &controllerServer{
-		DefaultControllerServer: csicommon.NewDefaultControllerServer(d),
+		events:        s3.events,
+		abortCtx:      s3.abortCtx,
+		locks:         newOperationLocks(),
+		listSecretDir: s3.options.ListVolumesSecretDir,
+	}
+}
+
+func (s3 *driver) newNodeServer() *nodeServer {
+	ns := &nodeServer{
+		nodeID:      s3.nodeID,
+		mounters:    make(map[string]mounter.Mounter),
+		statuses:    make(map[string]*volumeStatus),
+		volumeLocks: newOperationLocks(),
+		targetLocks: newOperationLocks(),
+		events:      s3.events,
+
+		reportStatuses: s3.options.ReportVolumeStatus,
+	}
+	if s3.options.CacheDir != "" {
+		ns.cache = mounter.NewCache(s3.options.CacheDir)
+	}
+	return ns
+}
+
+// newServers creates GRPC servers of the services served in the mode,
+// servers of other services stay nil
+func (s3 *driver) newServers() {
+	s3.ids = s3.newIdentityServer()
+	if s3.options.node() {
+		s3.ns = s3.newNodeServer()
 	}
+	if s3.options.controller() {
+		s3.cs = s3.newControllerServer()
+	}
+}
+
+// controllerService returns the controller server or nil if it isn't served.
+// Interface values holding nil pointers are not nil, so they are converted here.
+func (s3 *driver) controllerService() csi.ControllerServer {
+	if s3.cs == nil {
+		return nil
+	}
+	return s3.cs
 }
 
-func (s3 *driver) newNodeServer(d *csicommon.CSIDriver) *nodeServer {
-	return &nodeServer{
-		DefaultNodeServer: csicommon.NewDefaultNodeServer(d),
+// nodeService returns the node server or nil if it isn't served
+func (s3 *driver) nodeService() csi.NodeServer {
+	if s3.ns == nil {
+		return nil
 	}
+	return s3.ns
 }
 
 func (s3 *driver) Run() {
-	glog.Infof("Driver: %v ", driverName)
-	glog.Infof("Version: %v ", vendorVersion)
-	// Initialize default library driver
+	logging.Infof("Driver: %v ", driverName)
+	logging.Infof("Version: %v ", vendorVersion)
+	if commit != "" {
+		logging.Infof("Commit: %v", commit)
+	}
+	if s3.options.Mode != "" {
+		logging.Infof("Mode: %v", s3.options.Mode)
+	}
+
+	s3.newServers()
+	if s3.ns != nil {
+		if s3.ns.cache != nil {
+			go s3.ns.cache.Run()
+		}
+		if s3.ns.reportStatuses {
+			go s3.ns.refreshStatuses()
+		}
+	}
+
+	if s3.options.ConfigFile != "" {
+		go s3.watchConfig()
+	}
+	if s3.options.MetricsAddress != "" {
+		go metrics.Serve(s3.options.MetricsAddress)
+	}
+	if s3.options.HealthAddress != "" {
+		go s3.ids.health.serve(s3.options.HealthAddress)
+	}
 
-	s3.driver.AddControllerServiceCapabilities([]csi.ControllerServiceCapability_RPC_Type{csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME})
-	s3.driver.AddVolumeCapabilityAccessModes([]csi.VolumeCapability_AccessMode_Mode{csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER})
+	s := newGRPCServer()
+	s.Start(s3.endpoint, s3.ids, s3.controllerService(), s3.nodeService())
 
-	// Create GRPC servers
-	s3.ids = s3.newIdentityServer(s3.driver)
-	s3.ns = s3.newNodeServer(s3.driver)
-	s3.cs = s3.newControllerServer(s3.driver)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		sig := <-signals
+		logging.Infof("Received %v, stopping", sig)
+		timeout := s3.options.ShutdownTimeout
+		if timeout <= 0 {
+			timeout = defaultShutdownTimeout
+		}
+		s.Stop(timeout, s3.abort)
+	}()
 
-	s := csicommon.NewNonBlockingGRPCServer()
-	s.Start(s3.endpoint, s3.ids, s3.cs, s3.ns)
 	s.Wait()
+	s3.abort()
+	s3.stopTracing()
+	logging.Infof("Stopped")
 }
@@ -4,11 +4,11 @@ import (
 	"log"
 	"os"
 
-	"github.com/yandex-cloud/k8s-csi-s3/pkg/driver"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/driver"
 
-	"github.com/kubernetes-csi/csi-test/pkg/sanity"
+	"github.com/kubernetes-csi/csi-test/v4/pkg/sanity"
 )
 
 var _ = Describe("S3Driver", func() {
@@ -19,24 +19,23 @@ var _ = Describe("S3Driver", func() {
 		if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
 			Expect(err).NotTo(HaveOccurred())
 		}
-		driver, err := driver.New("test-node", csiEndpoint)
+		driver, err := driver.New("test-node", csiEndpoint, driver.Options{})
 		if err != nil {
 			log.Fatal(err)
 		}
 		go driver.Run()
 
 		Describe("CSI sanity", func() {
-			sanityCfg := &sanity.Config{
-				TargetPath:  os.TempDir() + "/geesefs-target",
-				StagingPath: os.TempDir() + "/geesefs-staging",
-				Address:     csiEndpoint,
-				SecretsFile: "../../test/secret.yaml",
-				TestVolumeParameters: map[string]string{
-					"mounter": "geesefs",
-					"bucket":  "testbucket0",
-				},
+			sanityCfg := sanity.NewTestConfig()
+			sanityCfg.TargetPath = os.TempDir() + "/geesefs-target"
+			sanityCfg.StagingPath = os.TempDir() + "/geesefs-staging"
+			sanityCfg.Address = csiEndpoint
+			sanityCfg.SecretsFile = "../../test/secret.yaml"
+			sanityCfg.TestVolumeParameters = map[string]string{
+				"mounter": "geesefs",
+				"bucket":  "testbucket0",
 			}
-			sanity.GinkgoTest(sanityCfg)
+			sanity.GinkgoTest(&sanityCfg)
 		})
 	})
 
@@ -46,78 +45,77 @@ var _ = Describe("S3Driver", func() {
 		if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
 			Expect(err).NotTo(HaveOccurred())
 		}
-		driver, err := driver.New("test-node", csiEndpoint)
+		driver, err := driver.New("test-node", csiEndpoint, driver.Options{})
 		if err != nil {
 			log.Fatal(err)
 		}
 		go driver.Run()
 
 		Describe("CSI sanity", func() {
-			sanityCfg := &sanity.Config{
-				TargetPath:  os.TempDir() + "/geesefs-no-bucket-target",
-				StagingPath: os.TempDir() + "/geesefs-no-bucket-staging",
-				Address:     csiEndpoint,
-				SecretsFile: "../../test/secret.yaml",
-				TestVolumeParameters: map[string]string{
-					"mounter": "geesefs",
-				},
+			sanityCfg := sanity.NewTestConfig()
+			sanityCfg.TargetPath = os.TempDir() + "/geesefs-no-bucket-target"
+			sanityCfg.StagingPath = os.TempDir() + "/geesefs-no-bucket-staging"
+			sanityCfg.Address = csiEndpoint
+			sanityCfg.SecretsFile = "../../test/secret.yaml"
+			sanityCfg.TestVolumeParameters = map[string]string{
+				"mounter": "geesefs",
 			}
-			sanity.GinkgoTest(sanityCfg)
+			sanity.GinkgoTest(&sanityCfg)
 		})
 	})
 
-/*	Context("s3fs", func() {
-		socket := "/tmp/csi-s3fs.sock"
-		csiEndpoint := "unix://" + socket
-		if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
-			Expect(err).NotTo(HaveOccurred())
-		}
-		driver, err := driver.New("test-node", csiEndpoint)
-		if err != nil {
-			log.Fatal(err)
-		}
-		go driver.Run()
+	/*
+		Context("s3fs", func() {
+			socket := "/tmp/csi-s3fs.sock"
+			csiEndpoint := "unix://" + socket
+			if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
+				Expect(err).NotTo(HaveOccurred())
+			}
+			driver, err := driver.New("test-node", csiEndpoint, driver.Options{})
+			if err != nil {
+				log.Fatal(err)
+			}
+			go driver.Run()
 
-		Describe("CSI sanity", func() {
-			sanityCfg := &sanity.Config{
-				TargetPath:  os.TempDir() + "/s3fs-target",
-				StagingPath: os.TempDir() + "/s3fs-staging",
-				Address:     csiEndpoint,
-				SecretsFile: "../../test/secret.yaml",
-				TestVolumeParameters: map[string]string{
+			Describe("CSI sanity", func() {
+				sanityCfg := sanity.NewTestConfig()
+				sanityCfg.TargetPath = os.TempDir() + "/s3fs-target"
+				sanityCfg.StagingPath = os.TempDir() + "/s3fs-staging"
+				sanityCfg.Address = csiEndpoint
+				sanityCfg.SecretsFile = "../../test/secret.yaml"
+				sanityCfg.TestVolumeParameters = map[string]string{
 					"mounter": "s3fs",
 					"bucket":  "testbucket1",
-				},
-			}
-			sanity.GinkgoTest(sanityCfg)
+				}
+				sanity.GinkgoTest(&sanityCfg)
+			})
 		})
-	})
 
-	Context("rclone", func() {
-		socket := "/tmp/csi-rclone.sock"
-		csiEndpoint := "unix://" + socket
+		Context("rclone", func() {
+			socket := "/tmp/csi-rclone.sock"
+			csiEndpoint := "unix://" + socket
 
-		if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
-			Expect(err).NotTo(HaveOccurred())
-		}
-		driver, err := driver.New("test-node", csiEndpoint)
-		if err != nil {
-			log.Fatal(err)
-		}
-		go driver.Run()
+			if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
+				Expect(err).NotTo(HaveOccurred())
+			}
+			driver, err := driver.New("test-node", csiEndpoint, driver.Options{})
+			if err != nil {
+				log.Fatal(err)
+			}
+			go driver.Run()
 
-		Describe("CSI sanity", func() {
-			sanityCfg := &sanity.Config{
-				TargetPath:  os.TempDir() + "/rclone-target",
-				StagingPath: os.TempDir() + "/rclone-staging",
-				Address:     csiEndpoint,
-				SecretsFile: "../../test/secret.yaml",
-				TestVolumeParameters: map[string]string{
+			Describe("CSI sanity", func() {
+				sanityCfg := sanity.NewTestConfig()
+				sanityCfg.TargetPath = os.TempDir() + "/rclone-target"
+				sanityCfg.StagingPath = os.TempDir() + "/rclone-staging"
+				sanityCfg.Address = csiEndpoint
+				sanityCfg.SecretsFile = "../../test/secret.yaml"
+				sanityCfg.TestVolumeParameters = map[string]string{
 					"mounter": "rclone",
 					"bucket":  "testbucket3",
-				},
-			}
-			sanity.GinkgoTest(sanityCfg)
+				}
+				sanity.GinkgoTest(&sanityCfg)
+			})
 		})
-	})*/
+	*/
 })
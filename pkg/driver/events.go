@@ -0,0 +1,134 @@
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/net/context"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/logging"
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/mounter"
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/s3"
+)
+
+const (
+	// Set in CreateVolume parameters by external-provisioner with --extra-create-metadata,
+	// the driver copies them to the volume context
+	pvcNameKey      = "csi.storage.k8s.io/pvc/name"
+	pvcNamespaceKey = "csi.storage.k8s.io/pvc/namespace"
+	pvNameKey       = "csi.storage.k8s.io/pv/name"
+	// Set in volume context by kubelet when podInfoOnMount is enabled
+	podNameKey      = "csi.storage.k8s.io/pod.name"
+	podNamespaceKey = "csi.storage.k8s.io/pod.namespace"
+	podUIDKey       = "csi.storage.k8s.io/pod.uid"
+
+	// Time to get objects which events are recorded about
+	eventObjectTimeout = 10 * time.Second
+)
+
+// Reasons of events
+const (
+	reasonProvisioningFailed = "ProvisioningFailed"
+	reasonMountFailed        = "MountFailed"
+	reasonMountTimeout       = "MountTimeout"
+	reasonInvalidCredentials = "InvalidCredentials"
+	reasonQuotaExceeded      = "QuotaExceeded"
+)
+
+// eventRecorder records Kubernetes Events about volume failures on PVCs,
+// PVs and Pods. A nil recorder doesn't record anything.
+type eventRecorder struct {
+	client   kubernetes.Interface
+	recorder record.EventRecorder
+}
+
+// newEventRecorder connects to the Kubernetes API using the service account of the pod
+func newEventRecorder(nodeID string) (*eventRecorder, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("Error getting Kubernetes API config: %v", err)
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating Kubernetes API client: %v", err)
+	}
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+	return &eventRecorder{
+		client:   client,
+		recorder: broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: driverName, Host: nodeID}),
+	}, nil
+}
+
+// provisioningFailed records a CreateVolume failure on the PVC
+func (e *eventRecorder) provisioningFailed(params map[string]string, err error) {
+	if e == nil || params[pvcNameKey] == "" {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), eventObjectTimeout)
+		defer cancel()
+		pvc, getErr := e.client.CoreV1().PersistentVolumeClaims(params[pvcNamespaceKey]).Get(ctx, params[pvcNameKey], metav1.GetOptions{})
+		if getErr != nil {
+			logging.Warningf("Error getting PVC %s/%s to record an event: %v", params[pvcNamespaceKey], params[pvcNameKey], getErr)
+			return
+		}
+		e.failed(pvc, reasonProvisioningFailed, err)
+	}()
+}
+
+// stageFailed records a NodeStageVolume failure on the PV
+func (e *eventRecorder) stageFailed(volumeContext map[string]string, err error) {
+	if e == nil || volumeContext[pvNameKey] == "" {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), eventObjectTimeout)
+		defer cancel()
+		pv, getErr := e.client.CoreV1().PersistentVolumes().Get(ctx, volumeContext[pvNameKey], metav1.GetOptions{})
+		if getErr != nil {
+			logging.Warningf("Error getting PV %s to record an event: %v", volumeContext[pvNameKey], getErr)
+			return
+		}
+		e.failed(pv, reasonMountFailed, err)
+	}()
+}
+
+// publishFailed records a NodePublishVolume failure on the pod
+func (e *eventRecorder) publishFailed(volumeContext map[string]string, err error) {
+	if e == nil || volumeContext[podNameKey] == "" {
+		return
+	}
+	e.failed(&corev1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "Pod",
+		Namespace:  volumeContext[podNamespaceKey],
+		Name:       volumeContext[podNameKey],
+		UID:        types.UID(volumeContext[podUIDKey]),
+	}, reasonMountFailed, err)
+}
+
+// failed records a warning with a reason more specific than
+// the default one if the cause of err is known
+func (e *eventRecorder) failed(object runtime.Object, reason string, err error) {
+	switch s3.ErrorCode(err) {
+	case "AccessDenied", "InvalidAccessKeyId", "SignatureDoesNotMatch", "InvalidToken", "ExpiredToken":
+		reason = reasonInvalidCredentials
+	case "QuotaExceeded", "TooManyBuckets":
+		reason = reasonQuotaExceeded
+	}
+	if errors.Is(err, mounter.ErrMountTimeout) {
+		reason = reasonMountTimeout
+	}
+	e.recorder.Event(object, corev1.EventTypeWarning, reason, err.Error())
+}
@@ -0,0 +1,69 @@
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/mounter"
+)
+
+var _ = Describe("eventRecorder", func() {
+	var e *eventRecorder
+	var recorder *record.FakeRecorder
+
+	BeforeEach(func() {
+		recorder = record.NewFakeRecorder(10)
+		e = &eventRecorder{
+			client: fake.NewSimpleClientset(
+				&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "pvc-1", Namespace: "default"}},
+				&corev1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: "pv-1"}},
+			),
+			recorder: recorder,
+		}
+	})
+
+	It("records provisioning failures on the PVC", func() {
+		e.provisioningFailed(map[string]string{pvcNameKey: "pvc-1", pvcNamespaceKey: "default"}, errors.New("boom"))
+		Eventually(recorder.Events).Should(Receive(Equal("Warning ProvisioningFailed boom")))
+	})
+
+	It("doesn't record events about unknown objects", func() {
+		e.provisioningFailed(map[string]string{}, errors.New("boom"))
+		e.provisioningFailed(map[string]string{pvcNameKey: "pvc-2", pvcNamespaceKey: "default"}, errors.New("boom"))
+		e.stageFailed(map[string]string{}, errors.New("boom"))
+		e.publishFailed(map[string]string{}, errors.New("boom"))
+		Consistently(recorder.Events, 200*time.Millisecond).ShouldNot(Receive())
+	})
+
+	It("records mount timeouts on the PV", func() {
+		err := fmt.Errorf("Error mounting volume: %w", mounter.ErrMountTimeout)
+		e.stageFailed(map[string]string{pvNameKey: "pv-1"}, err)
+		Eventually(recorder.Events).Should(Receive(Equal("Warning MountTimeout " + err.Error())))
+	})
+
+	It("records invalid credentials and exceeded quotas on the pod", func() {
+		volumeContext := map[string]string{podNameKey: "pod-1", podNamespaceKey: "default", podUIDKey: "uid"}
+		e.publishFailed(volumeContext, minio.ErrorResponse{Code: "InvalidAccessKeyId", Message: "bad key"})
+		Expect(recorder.Events).To(Receive(Equal("Warning InvalidCredentials bad key")))
+		e.publishFailed(volumeContext, fmt.Errorf("Error creating bucket: %w", minio.ErrorResponse{Code: "TooManyBuckets", Message: "too many"}))
+		Expect(recorder.Events).To(Receive(Equal("Warning QuotaExceeded Error creating bucket: too many")))
+		e.publishFailed(volumeContext, errors.New("boom"))
+		Expect(recorder.Events).To(Receive(Equal("Warning MountFailed boom")))
+	})
+
+	It("does nothing if it's nil", func() {
+		var e *eventRecorder
+		e.provisioningFailed(map[string]string{pvcNameKey: "pvc-1"}, errors.New("boom"))
+		e.stageFailed(map[string]string{pvNameKey: "pv-1"}, errors.New("boom"))
+		e.publishFailed(map[string]string{podNameKey: "pod-1"}, errors.New("boom"))
+	})
+})
@@ -0,0 +1,151 @@
+package driver
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	systemd "github.com/coreos/go-systemd/v22/dbus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/logging"
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/mounter"
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/s3"
+)
+
+const (
+	healthCheckTimeout = 5 * time.Second
+)
+
+// healthChecker checks that the plugin is able to serve volumes
+type healthChecker struct {
+	endpoint string
+	options  Options
+}
+
+// check runs the checks enabled in options. Mounter binaries are only
+// checked in node mode, the controller doesn't mount volumes.
+func (h *healthChecker) check() error {
+	if h.options.HealthCheckMounters && h.options.node() {
+		if err := mounter.CheckBinaries(); err != nil {
+			return err
+		}
+	}
+	if h.options.HealthCheckSystemd {
+		if err := checkSystemd(); err != nil {
+			return err
+		}
+	}
+	if h.options.HealthS3SecretDir != "" {
+		if err := checkS3(h.options.HealthS3SecretDir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkSocket checks that the CSI endpoint answers gRPC calls
+func (h *healthChecker) checkSocket() error {
+	proto, addr, err := parseEndpoint(h.endpoint)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, addr, grpc.WithInsecure(), grpc.WithBlock(),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, proto, addr)
+		}))
+	if err != nil {
+		return fmt.Errorf("Error connecting to CSI endpoint %s: %v", h.endpoint, err)
+	}
+	defer conn.Close()
+	if _, err := csi.NewIdentityClient(conn).GetPluginInfo(ctx, &csi.GetPluginInfoRequest{}); err != nil {
+		return fmt.Errorf("Error calling CSI endpoint %s: %v", h.endpoint, err)
+	}
+	return nil
+}
+
+func checkSystemd() error {
+	conn, err := systemd.New()
+	if err != nil {
+		return fmt.Errorf("Error connecting to systemd dbus service: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.GetManagerProperty("Version"); err != nil {
+		return fmt.Errorf("Error calling systemd dbus service: %v", err)
+	}
+	return nil
+}
+
+// readSecretDir reads a secret like the ones of volumes from a mounted secret directory
+func readSecretDir(secretDir string) (map[string]string, error) {
+	files, err := ioutil.ReadDir(secretDir)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading S3 secret: %v", err)
+	}
+	secret := make(map[string]string)
+	for _, f := range files {
+		// Skip ..data and other internal entries of mounted secrets
+		if f.IsDir() || strings.HasPrefix(f.Name(), "..") {
+			continue
+		}
+		value, err := ioutil.ReadFile(filepath.Join(secretDir, f.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("Error reading S3 secret: %v", err)
+		}
+		secret[f.Name()] = strings.TrimSpace(string(value))
+	}
+	return secret, nil
+}
+
+// checkS3 checks that S3 accepts credentials of a mounted secret
+func checkS3(secretDir string) error {
+	secret, err := readSecretDir(secretDir)
+	if err != nil {
+		return err
+	}
+	client, err := s3.NewClientFromSecret(secret)
+	if err != nil {
+		return fmt.Errorf("Failed to initialize S3 client: %v", err)
+	}
+	if err := client.Ping(healthCheckTimeout); err != nil {
+		return fmt.Errorf("Error accessing S3 at %s: %v", client.Config.Endpoint, err)
+	}
+	return nil
+}
+
+// serve serves /healthz for liveness probes, which only checks the CSI
+// endpoint, and /readyz for readiness probes, which runs all checks
+func (h *healthChecker) serve(address string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeHealth(w, h.checkSocket())
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		err := h.checkSocket()
+		if err == nil {
+			err = h.check()
+		}
+		writeHealth(w, err)
+	})
+	logging.Infof("Serving health checks on %s", address)
+	if err := http.ListenAndServe(address, mux); err != nil {
+		logging.Errorf("Error serving health checks on %s: %v", address, err)
+	}
+}
+
+func writeHealth(w http.ResponseWriter, err error) {
+	if err != nil {
+		logging.Warningf("Health check failed: %v", err)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
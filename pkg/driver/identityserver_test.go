@@ -0,0 +1,63 @@
+package driver
+
+import (
+	"context"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/mounter"
+)
+
+var _ = Describe("Driver name and version", func() {
+	var origName, origVersion, origCommit string
+
+	BeforeEach(func() {
+		origName, origVersion, origCommit = driverName, vendorVersion, commit
+	})
+
+	AfterEach(func() {
+		driverName, vendorVersion, commit = origName, origVersion, origCommit
+		mounter.Configure(mounter.DefaultSettings)
+	})
+
+	pluginInfo := func() *csi.GetPluginInfoResponse {
+		resp, err := (&identityServer{}).GetPluginInfo(context.Background(), &csi.GetPluginInfoRequest{})
+		Expect(err).NotTo(HaveOccurred())
+		return resp
+	}
+
+	It("uses the default name", func() {
+		d, err := New("node-1", "unix:///tmp/csi.sock", Options{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(d.config.DriverName).To(Equal(defaultDriverName))
+		Expect(d.config.PluginDir).To(Equal("/var/lib/kubelet/plugins/" + defaultDriverName))
+		Expect(pluginInfo().GetName()).To(Equal(defaultDriverName))
+	})
+
+	It("uses the name from the flag", func() {
+		d, err := New("node-1", "unix:///tmp/csi.sock", Options{DriverName: "s3.csi.example.com"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(d.config.PluginDir).To(Equal("/var/lib/kubelet/plugins/s3.csi.example.com"))
+		Expect(pluginInfo().GetName()).To(Equal("s3.csi.example.com"))
+		Expect(pluginInfo().GetVendorVersion()).To(Equal(origVersion))
+	})
+
+	It("rejects invalid names", func() {
+		for _, name := range []string{"-s3.csi", "s3.csi.", "s3 csi", strings.Repeat("a", 64)} {
+			_, err := New("node-1", "unix:///tmp/csi.sock", Options{DriverName: name})
+			Expect(err).To(MatchError(ContainSubstring("must be at most 63 characters long")))
+		}
+	})
+
+	It("reports the commit of the build", func() {
+		Expect(pluginInfo().GetManifest()).To(BeEmpty())
+		commit = "0123abc"
+		Expect(pluginInfo().GetManifest()).To(Equal(map[string]string{"commit": "0123abc"}))
+		version, c := Version()
+		Expect(version).To(Equal(vendorVersion))
+		Expect(c).To(Equal("0123abc"))
+	})
+})
@@ -0,0 +1,46 @@
+package driver
+
+import (
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// operationLocks serializes operations by keys like volume IDs. An operation
+// on a key which is already locked fails with Aborted instead of waiting, as
+// the CSI spec recommends, so that the caller retries it later.
+type operationLocks struct {
+	mu   sync.Mutex
+	keys map[string]struct{}
+}
+
+func newOperationLocks() *operationLocks {
+	return &operationLocks{keys: make(map[string]struct{})}
+}
+
+// tryAcquire locks key and returns true, or returns false if key is already locked
+func (l *operationLocks) tryAcquire(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.keys[key]; ok {
+		return false
+	}
+	l.keys[key] = struct{}{}
+	return true
+}
+
+func (l *operationLocks) release(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.keys, key)
+}
+
+// lock locks key and returns a function unlocking it, or an Aborted error if
+// another operation holds the lock
+func (l *operationLocks) lock(key string) (func(), error) {
+	if !l.tryAcquire(key) {
+		return nil, status.Errorf(codes.Aborted, "An operation on %s is already in progress", key)
+	}
+	return func() { l.release(key) }, nil
+}
@@ -0,0 +1,63 @@
+package driver
+
+import (
+	"sync"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var _ = Describe("operationLocks", func() {
+	var locks *operationLocks
+
+	BeforeEach(func() {
+		locks = newOperationLocks()
+	})
+
+	It("locks keys independently", func() {
+		Expect(locks.tryAcquire("a")).To(BeTrue())
+		Expect(locks.tryAcquire("a")).To(BeFalse())
+		Expect(locks.tryAcquire("b")).To(BeTrue())
+	})
+
+	It("acquires a key again after it's released", func() {
+		Expect(locks.tryAcquire("a")).To(BeTrue())
+		locks.release("a")
+		Expect(locks.tryAcquire("a")).To(BeTrue())
+		Expect(locks.keys).To(HaveLen(1))
+	})
+
+	It("returns Aborted for a locked key", func() {
+		unlock, err := locks.lock("a")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = locks.lock("a")
+		Expect(status.Code(err)).To(Equal(codes.Aborted))
+		unlock()
+		unlock, err = locks.lock("a")
+		Expect(err).NotTo(HaveOccurred())
+		unlock()
+		Expect(locks.keys).To(BeEmpty())
+	})
+
+	It("lets one of concurrent operations acquire a key", func() {
+		const n = 50
+		var wg sync.WaitGroup
+		acquired := make(chan struct{}, n)
+		start := make(chan struct{})
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+				if locks.tryAcquire("a") {
+					acquired <- struct{}{}
+				}
+			}()
+		}
+		close(start)
+		wg.Wait()
+		Expect(acquired).To(HaveLen(1))
+	})
+})
@@ -0,0 +1,63 @@
+package driver
+
+import (
+	"context"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/mounter"
+)
+
+var _ = Describe("Modes", func() {
+	table.DescribeTable("registers services of the mode",
+		func(mode string, controller, node bool) {
+			d := &driver{options: Options{Mode: mode}}
+			d.newServers()
+			Expect(d.ids).NotTo(BeNil())
+			Expect(d.controllerService() != nil).To(Equal(controller))
+			Expect(d.nodeService() != nil).To(Equal(node))
+
+			resp, err := d.ids.GetPluginCapabilities(context.Background(), &csi.GetPluginCapabilitiesRequest{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.GetCapabilities() != nil).To(Equal(controller))
+		},
+		table.Entry("default", "", true, true),
+		table.Entry("all", ModeAll, true, true),
+		table.Entry("controller", ModeController, true, false),
+		table.Entry("node", ModeNode, false, true),
+	)
+
+	It("rejects unknown modes", func() {
+		_, err := New("node-1", "unix:///tmp/csi.sock", Options{Mode: "both"})
+		Expect(err).To(MatchError(ContainSubstring(`Unknown mode "both"`)))
+	})
+
+	Describe("health checks", func() {
+		BeforeEach(func() {
+			settings := mounter.DefaultSettings
+			settings.GeesefsPath = "/nonexistent/geesefs"
+			mounter.Configure(settings)
+		})
+
+		AfterEach(func() {
+			mounter.Configure(mounter.DefaultSettings)
+		})
+
+		table.DescribeTable("check mounter binaries only on nodes",
+			func(mode string, fails bool) {
+				h := &healthChecker{options: Options{Mode: mode, HealthCheckMounters: true}}
+				if fails {
+					Expect(h.check()).To(MatchError(ContainSubstring("/nonexistent/geesefs")))
+				} else {
+					Expect(h.check()).To(Succeed())
+				}
+			},
+			table.Entry("all", ModeAll, true),
+			table.Entry("node", ModeNode, true),
+			table.Entry("controller", ModeController, false),
+		)
+	})
+})
@@ -20,49 +20,200 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
-	"regexp"
-	"strconv"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/logging"
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/metrics"
 	"github.com/yandex-cloud/k8s-csi-s3/pkg/mounter"
 	"github.com/yandex-cloud/k8s-csi-s3/pkg/s3"
-	"github.com/golang/glog"
 	"golang.org/x/net/context"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"k8s.io/kubernetes/pkg/util/mount"
+)
 
-	csicommon "github.com/kubernetes-csi/drivers/pkg/csi-common"
+const (
+	// Set in volume context by kubelet for CSI inline volumes
+	ephemeralKey = "csi.storage.k8s.io/ephemeral"
+	// Time to write or remove the status of a volume on the node
+	nodeStatusTimeout = 10 * time.Second
+	// Nodes rewrite statuses of staged volumes at this interval, ListVolumes
+	// ignores statuses which weren't rewritten for nodeStatusExpiry, like ones
+	// left by nodes which restarted or were removed with volumes staged
+	nodeStatusRefreshInterval = 5 * time.Minute
+	nodeStatusExpiry          = 3 * nodeStatusRefreshInterval
+	// Prefix of volume attributes set by kubelet, like the pod name
+	kubeletAttributePrefix = "csi.storage.k8s.io/"
 )
 
+// ephemeralAttributes may be set in attributes of inline volumes. Anyone who
+// can create a pod sets them, and mounters run as root on the node, so mount
+// options and the mounter are only accepted from storage classes.
+var ephemeralAttributes = map[string]bool{
+	mounter.BucketKey:         true,
+	mounter.PrefixKey:         true,
+	mounter.MemoryLimitKey:    true,
+	mounter.ReadAheadKey:      true,
+	mounter.DirModeKey:        true,
+	mounter.FileModeKey:       true,
+	mounter.UidKey:            true,
+	mounter.GidKey:            true,
+	mounter.CacheSizeKey:      true,
+	mounter.MountTimeoutKey:   true,
+	mounter.UnmountTimeoutKey: true,
+}
+
+// checkEphemeralAttributes returns an InvalidArgument error if attributes of
+// an inline volume contain keys which pods aren't allowed to set
+func checkEphemeralAttributes(attrib map[string]string) error {
+	var rejected []string
+	for key := range attrib {
+		if !ephemeralAttributes[key] && !strings.HasPrefix(key, kubeletAttributePrefix) {
+			rejected = append(rejected, key)
+		}
+	}
+	if len(rejected) > 0 {
+		sort.Strings(rejected)
+		return status.Errorf(codes.InvalidArgument, "Attributes %s are not allowed in ephemeral volumes", strings.Join(rejected, ", "))
+	}
+	return nil
+}
+
 type nodeServer struct {
-	*csicommon.DefaultNodeServer
+	nodeID string
+	cache  *mounter.Cache
+	events *eventRecorder
+
+	mu sync.Mutex
+	// Mounters of volumes mounted by this process by target path
+	mounters map[string]mounter.Mounter
+	// Write statuses of staged volumes for ListVolumes
+	reportStatuses bool
+	// Last reported statuses of staged volumes by volume ID
+	statuses map[string]*volumeStatus
+
+	// Stage and unstage of a volume are serialized by volume ID,
+	// publish and unpublish by target path
+	volumeLocks *operationLocks
+	targetLocks *operationLocks
+}
+
+// volumeStatus is the status of a staged volume with the secrets to write it
+type volumeStatus struct {
+	secrets  map[string]string
+	abnormal bool
+	message  string
+}
+
+func getMeta(bucketName, prefix string, context map[string]string, capability *csi.VolumeCapability, cfg *s3.Config) (*s3.FSMeta, error) {
+	meta := &s3.FSMeta{
+		BucketName: bucketName,
+		Prefix:     prefix,
+		Mounter:    context[mounter.TypeKey],
+	}
+	if err := mounter.ParseParams(meta, cfg, context); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := mounter.ApplyMountGroup(meta, capability.GetMount().GetVolumeMountGroup()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return meta, nil
+}
+
+// prepareCache sets up the disk cache directory if it's requested for the volume
+func (ns *nodeServer) prepareCache(volumeID string, meta *s3.FSMeta, m mounter.Mounter) error {
+	if meta.CacheSize == 0 {
+		return nil
+	}
+	if ns.cache == nil {
+		logging.Warningf("Volume %s requests a disk cache, but the node has no cache directory, mounting without cache", volumeID)
+		return nil
+	}
+	if !m.Capabilities().Cache {
+		logging.Warningf("Mounter of volume %s doesn't support disk cache, mounting without cache", volumeID)
+		return nil
+	}
+	dir, err := ns.cache.Prepare(volumeID, meta.CacheSize)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	meta.CacheDir = dir
+	return nil
+}
+
+// mountVolume starts the mounter for the volume at target
+func (ns *nodeServer) mountVolume(ctx context.Context, volumeID, target, bucketName, prefix string, volumeContext map[string]string,
+	capability *csi.VolumeCapability, readOnly bool, secrets map[string]string) error {
+	client, err := s3.NewClientFromSecret(secrets)
+	if err != nil {
+		return fmt.Errorf("failed to initialize S3 client: %s", err)
+	}
+	meta, err := getMeta(bucketName, prefix, volumeContext, capability, client.Config)
+	if err != nil {
+		return err
+	}
+	m, err := mounter.New(meta, client.Config)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if readOnly {
+		if !m.Capabilities().ReadOnly {
+			return status.Errorf(codes.InvalidArgument, "Mounter %s can't mount volumes read-only", meta.Mounter)
+		}
+		meta.ReadOnly = true
+	}
+	if err := ns.prepareCache(volumeID, meta, m); err != nil {
+		return err
+	}
+	if err := m.Mount(ctx, target, volumeID); err != nil {
+		return err
+	}
+	ns.mu.Lock()
+	ns.mounters[target] = m
+	metrics.SetActiveMounts(len(ns.mounters))
+	ns.mu.Unlock()
+	return nil
+}
+
+// volumeMounter returns the mounter serving the volume at target
+func (ns *nodeServer) volumeMounter(volumeID, target string) mounter.Mounter {
+	ns.mu.Lock()
+	m := ns.mounters[target]
+	ns.mu.Unlock()
+	if m == nil {
+		m = mounter.Detect(volumeID, target)
+	}
+	return m
 }
 
-func getMeta(bucketName, prefix string, context map[string]string) *s3.FSMeta {
-	mountOptions := make([]string, 0)
-	mountOptStr := context[mounter.OptionsKey]
-	if mountOptStr != "" {
-		re, _ := regexp.Compile(`([^\s"]+|"([^"\\]+|\\")*")+`)
-		re2, _ := regexp.Compile(`"([^"\\]+|\\")*"`)
-		re3, _ := regexp.Compile(`\\(.)`)
-		for _, opt := range re.FindAll([]byte(mountOptStr), -1) {
-			// Unquote options
-			opt = re2.ReplaceAllFunc(opt, func(q []byte) []byte {
-				return re3.ReplaceAll(q[1 : len(q)-1], []byte("$1"))
-			})
-			mountOptions = append(mountOptions, string(opt))
+// unmountVolume stops the mounter serving the volume at target
+func (ns *nodeServer) unmountVolume(ctx context.Context, volumeID, target string) error {
+	m := ns.volumeMounter(volumeID, target)
+	if err := m.Unmount(ctx, target, volumeID); err != nil {
+		if m.Status(target, volumeID) == nil {
+			return err
+		}
+		// The volume is already unmounted or its mount is broken
+		logging.FromContext(ctx).V(4).Infof("Error unmounting volume %s from %s: %v", volumeID, target, err)
+		if err := mounter.ForceUnmount(target); err != nil {
+			return err
 		}
 	}
-	capacity, _ := strconv.ParseInt(context["capacity"], 10, 64)
-	return &s3.FSMeta{
-		BucketName:    bucketName,
-		Prefix:        prefix,
-		Mounter:       context[mounter.TypeKey],
-		MountOptions:  mountOptions,
-		CapacityBytes: capacity,
+	ns.mu.Lock()
+	delete(ns.mounters, target)
+	metrics.SetActiveMounts(len(ns.mounters))
+	ns.mu.Unlock()
+	if ns.cache != nil {
+		if err := ns.cache.Remove(volumeID); err != nil {
+			logging.FromContext(ctx).Errorf("Error removing cache of volume %s: %v", volumeID, err)
+		}
 	}
+	return nil
 }
 
 func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
@@ -77,35 +228,43 @@ func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 	if len(volumeID) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "Volume ID missing in request")
 	}
-	if len(stagingTargetPath) == 0 {
-		return nil, status.Error(codes.InvalidArgument, "Staging Target path missing in request")
-	}
 	if len(targetPath) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "Target path missing in request")
 	}
-
-	notMnt, err := checkMount(stagingTargetPath)
+	unlock, err := ns.targetLocks.lock(targetPath)
 	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, err
+	}
+	defer unlock()
+	if req.GetVolumeContext()[ephemeralKey] == "true" {
+		return ns.publishEphemeralVolume(ctx, req)
 	}
-	if notMnt {
+	if len(stagingTargetPath) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Staging Target path missing in request")
+	}
+
+	if err := ns.volumeMounter(volumeID, stagingTargetPath).Status(stagingTargetPath, volumeID); err != nil {
 		// Staged mount is dead by some reason. Revive it
-		bucketName, prefix := volumeIDToBucketPrefix(volumeID)
-		s3, err := s3.NewClientFromSecret(req.GetSecrets())
-		if err != nil {
-			return nil, fmt.Errorf("failed to initialize S3 client: %s", err)
-		}
-		meta := getMeta(bucketName, prefix, req.VolumeContext)
-		mounter, err := mounter.New(meta, s3.Config)
+		logging.FromContext(ctx).Warningf("Staged volume %s is not available: %v, remounting", volumeID, err)
+		unlockVolume, err := ns.volumeLocks.lock(volumeID)
 		if err != nil {
 			return nil, err
 		}
-		if err := mounter.Mount(stagingTargetPath, volumeID); err != nil {
+		defer unlockVolume()
+		if _, err := checkMount(stagingTargetPath); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		bucketName, prefix := volumeIDToBucketPrefix(volumeID)
+		err = ns.mountVolume(ctx, volumeID, stagingTargetPath, bucketName, prefix, req.GetVolumeContext(),
+			req.GetVolumeCapability(), false, req.GetSecrets())
+		ns.reportStatus(ctx, volumeID, req.GetSecrets(), err)
+		if err != nil {
+			ns.events.publishFailed(req.GetVolumeContext(), err)
 			return nil, err
 		}
 	}
 
-	notMnt, err = checkMount(targetPath)
+	notMnt, err := checkMount(targetPath)
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
@@ -118,18 +277,65 @@ func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 	mountFlags := req.GetVolumeCapability().GetMount().GetMountFlags()
 	attrib := req.GetVolumeContext()
 
-	glog.V(4).Infof("target %v\nreadonly %v\nvolumeId %v\nattributes %v\nmountflags %v\n",
-		targetPath, readOnly, volumeID, attrib, mountFlags)
+	logging.FromContext(ctx).With(
+		"target", targetPath,
+		"readonly", readOnly,
+		"attributes", attrib,
+		"mount_flags", mountFlags,
+	).V(4).Info("Publishing volume")
 
 	cmd := exec.Command("mount", "--bind", stagingTargetPath, targetPath)
 	cmd.Stderr = os.Stderr
-	glog.V(3).Infof("Binding volume %v from %v to %v", volumeID, stagingTargetPath, targetPath)
+	logging.FromContext(ctx).V(3).Infof("Binding volume %v from %v to %v", volumeID, stagingTargetPath, targetPath)
 	out, err := cmd.Output()
 	if err != nil {
-		return nil, fmt.Errorf("Error running mount --bind %v %v: %s", stagingTargetPath, targetPath, out)
+		err = fmt.Errorf("Error running mount --bind %v %v: %s", stagingTargetPath, targetPath, out)
+		ns.events.publishFailed(attrib, err)
+		return nil, err
 	}
 
-	glog.V(4).Infof("s3: volume %s successfully mounted to %s", volumeID, targetPath)
+	logging.FromContext(ctx).V(4).Infof("s3: volume %s successfully mounted to %s", volumeID, targetPath)
+
+	return &csi.NodePublishVolumeResponse{}, nil
+}
+
+// publishEphemeralVolume mounts an inline volume directly at the target path.
+// Inline volumes are not staged, so the bucket and the prefix are taken from
+// volume attributes and credentials from the node publish secret
+func (ns *nodeServer) publishEphemeralVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
+	volumeID := req.GetVolumeId()
+	targetPath := req.GetTargetPath()
+	attrib := req.GetVolumeContext()
+
+	bucketName := attrib[mounter.BucketKey]
+	if bucketName == "" {
+		return nil, status.Error(codes.InvalidArgument, "Bucket attribute missing in ephemeral volume")
+	}
+	if err := checkEphemeralAttributes(attrib); err != nil {
+		return nil, err
+	}
+	if len(req.GetSecrets()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Ephemeral volume requires nodePublishSecretRef with S3 credentials")
+	}
+
+	notMnt, err := checkMount(targetPath)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if !notMnt {
+		return &csi.NodePublishVolumeResponse{}, nil
+	}
+
+	logging.FromContext(ctx).V(3).Infof("Mounting ephemeral volume %v from bucket %v prefix %v to %v",
+		volumeID, bucketName, attrib[mounter.PrefixKey], targetPath)
+	err = ns.mountVolume(ctx, volumeID, targetPath, bucketName, attrib[mounter.PrefixKey], attrib,
+		req.GetVolumeCapability(), req.GetReadonly(), req.GetSecrets())
+	if err != nil {
+		ns.events.publishFailed(attrib, err)
+		return nil, err
+	}
+
+	logging.FromContext(ctx).V(4).Infof("s3: ephemeral volume %s successfully mounted to %s", volumeID, targetPath)
 
 	return &csi.NodePublishVolumeResponse{}, nil
 }
@@ -145,11 +351,46 @@ func (ns *nodeServer) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpu
 	if len(targetPath) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "Target path missing in request")
 	}
+	unlock, err := ns.targetLocks.lock(targetPath)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
 
-	if err := mounter.Unmount(targetPath); err != nil {
+	notMnt, err := mount.New("").IsLikelyNotMountPoint(targetPath)
+	if err != nil && mount.IsCorruptedMnt(err) {
+		// The FUSE process is dead, but the target is still mounted
+		logging.FromContext(ctx).Warningf("Mountpoint %s is corrupted: %v", targetPath, err)
+		notMnt, err = false, nil
+	}
+	if err != nil && !os.IsNotExist(err) {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
-	glog.V(4).Infof("s3: volume %s has been unmounted.", volumeID)
+	if err == nil && !notMnt {
+		// Ephemeral volumes are served by their own FUSE process
+		// while persistent volumes are bind-mounted from staging path
+		ns.mu.Lock()
+		_, ephemeral := ns.mounters[targetPath]
+		ns.mu.Unlock()
+		if !ephemeral {
+			ephemeral, err = mounter.HasFuseProcess(volumeID, targetPath)
+			if err != nil {
+				return nil, status.Error(codes.Internal, err.Error())
+			}
+		}
+		if ephemeral {
+			err = ns.unmountVolume(ctx, volumeID, targetPath)
+		} else {
+			err = mounter.Unmount(targetPath)
+		}
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+	if err := os.Remove(targetPath); err != nil && !os.IsNotExist(err) {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	logging.FromContext(ctx).V(4).Infof("s3: volume %s has been unmounted.", volumeID)
 
 	return &csi.NodeUnpublishVolumeResponse{}, nil
 }
@@ -171,27 +412,27 @@ func (ns *nodeServer) NodeStageVolume(ctx context.Context, req *csi.NodeStageVol
 	if req.VolumeCapability == nil {
 		return nil, status.Error(codes.InvalidArgument, "NodeStageVolume Volume Capability must be provided")
 	}
+	unlock, err := ns.volumeLocks.lock(volumeID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
 
 	notMnt, err := checkMount(stagingTargetPath)
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 	if !notMnt {
+		ns.reportStatus(ctx, volumeID, req.GetSecrets(), nil)
 		return &csi.NodeStageVolumeResponse{}, nil
 	}
-	client, err := s3.NewClientFromSecret(req.GetSecrets())
-	if err != nil {
-		return nil, fmt.Errorf("failed to initialize S3 client: %s", err)
-	}
-
-	meta := getMeta(bucketName, prefix, req.VolumeContext)
-	mounter, err := mounter.New(meta, client.Config)
+	err = ns.mountVolume(ctx, volumeID, stagingTargetPath, bucketName, prefix, req.GetVolumeContext(),
+		req.GetVolumeCapability(), false, req.GetSecrets())
 	if err != nil {
+		ns.events.stageFailed(req.GetVolumeContext(), err)
 		return nil, err
 	}
-	if err := mounter.Mount(stagingTargetPath, volumeID); err != nil {
-		return nil, err
-	}
+	ns.reportStatus(ctx, volumeID, req.GetSecrets(), nil)
 
 	return &csi.NodeStageVolumeResponse{}, nil
 }
@@ -207,50 +448,178 @@ func (ns *nodeServer) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstag
 	if len(stagingTargetPath) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "Target path missing in request")
 	}
-
-	proc, err := mounter.FindFuseMountProcess(stagingTargetPath)
+	unlock, err := ns.volumeLocks.lock(volumeID)
 	if err != nil {
 		return nil, err
 	}
-	exists := false
-	if proc == nil {
-		exists, err = mounter.SystemdUnmount(volumeID)
-		if exists && err != nil {
-			return nil, err
+	defer unlock()
+
+	if err := ns.unmountVolume(ctx, volumeID, stagingTargetPath); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	logging.FromContext(ctx).V(4).Infof("s3: volume %s has been unmounted from stage path %v.", volumeID, stagingTargetPath)
+	ns.clearStatus(ctx, volumeID)
+
+	return &csi.NodeUnstageVolumeResponse{}, nil
+}
+
+// reportStatus writes the status of a staged volume on this node, which is
+// reported by ListVolumes, and keeps it to be rewritten periodically. Statuses
+// are only reported if it's enabled and the volume has metadata written by
+// CreateVolume. Writing them is best-effort: a volume whose status can't be
+// written, for example with read-only credentials, isn't retried until it's
+// staged again.
+func (ns *nodeServer) reportStatus(ctx context.Context, volumeID string, secrets map[string]string, mountErr error) {
+	if !ns.reportStatuses {
+		return
+	}
+	st := &volumeStatus{secrets: secrets, message: "Volume is mounted"}
+	if mountErr != nil {
+		st.abnormal = true
+		st.message = mountErr.Error()
+	}
+	ns.mu.Lock()
+	delete(ns.statuses, volumeID)
+	ns.mu.Unlock()
+
+	written, err := ns.writeStatus(ctx, volumeID, st, true)
+	if err != nil {
+		logging.FromContext(ctx).Warningf("Error writing status of volume %s, it isn't reported: %v", volumeID, err)
+		return
+	}
+	if !written {
+		logging.FromContext(ctx).V(4).Infof("Volume %s has no metadata, its status isn't reported", volumeID)
+		return
+	}
+	ns.mu.Lock()
+	ns.statuses[volumeID] = st
+	ns.mu.Unlock()
+}
+
+// refreshStatuses periodically rewrites statuses of staged volumes, volumes
+// whose statuses can't be written are not retried
+func (ns *nodeServer) refreshStatuses() {
+	for {
+		time.Sleep(nodeStatusRefreshInterval)
+		ns.mu.Lock()
+		statuses := make(map[string]*volumeStatus, len(ns.statuses))
+		for volumeID, st := range ns.statuses {
+			statuses[volumeID] = st
+		}
+		ns.mu.Unlock()
+		for volumeID, st := range statuses {
+			if _, err := ns.writeStatus(context.Background(), volumeID, st, false); err != nil {
+				logging.Warningf("Error writing status of volume %s, it isn't reported anymore: %v", volumeID, err)
+				ns.mu.Lock()
+				if ns.statuses[volumeID] == st {
+					delete(ns.statuses, volumeID)
+				}
+				ns.mu.Unlock()
+			}
+		}
+	}
+}
+
+// writeStatus writes the status of a volume on this node. If requireMeta is
+// set, the status is only written if the volume has metadata.
+func (ns *nodeServer) writeStatus(ctx context.Context, volumeID string, st *volumeStatus, requireMeta bool) (bool, error) {
+	bucketName, prefix := volumeIDToBucketPrefix(volumeID)
+	timeout, cancel := context.WithTimeout(context.Background(), nodeStatusTimeout)
+	defer cancel()
+	client, err := s3.NewClientFromSecret(st.secrets)
+	if err != nil {
+		return false, err
+	}
+	client = client.WithContext(ctx).WithAbort(timeout)
+	if requireMeta {
+		meta, _, err := client.ReadVolumeMeta(bucketName, prefix)
+		if err != nil || meta == nil {
+			return false, err
 		}
 	}
-	if !exists {
-		err = mounter.FuseUnmount(stagingTargetPath)
+	err = client.WriteNodeStatus(bucketName, prefix, &s3.NodeStatus{
+		NodeID:   ns.nodeID,
+		Abnormal: st.abnormal,
+		Message:  st.message,
+		Time:     time.Now(),
+	})
+	return err == nil, err
+}
+
+// clearStatus removes the status of an unstaged volume on this node
+func (ns *nodeServer) clearStatus(ctx context.Context, volumeID string) {
+	if !ns.reportStatuses {
+		return
+	}
+	ns.mu.Lock()
+	st, ok := ns.statuses[volumeID]
+	delete(ns.statuses, volumeID)
+	ns.mu.Unlock()
+	if !ok {
+		logging.FromContext(ctx).V(4).Infof("Status of volume %s isn't known, it may have been written "+
+			"before a restart and is kept until it expires", volumeID)
+		return
 	}
-	glog.V(4).Infof("s3: volume %s has been unmounted from stage path %v.", volumeID, stagingTargetPath)
 
-	return &csi.NodeUnstageVolumeResponse{}, nil
+	bucketName, prefix := volumeIDToBucketPrefix(volumeID)
+	timeout, cancel := context.WithTimeout(context.Background(), nodeStatusTimeout)
+	defer cancel()
+	client, err := s3.NewClientFromSecret(st.secrets)
+	if err == nil {
+		err = client.WithContext(ctx).WithAbort(timeout).RemoveNodeStatus(bucketName, prefix, ns.nodeID)
+	}
+	if err != nil {
+		logging.FromContext(ctx).Warningf("Error removing status of volume %s: %v", volumeID, err)
+	}
 }
 
 // NodeGetCapabilities returns the supported capabilities of the node server
 func (ns *nodeServer) NodeGetCapabilities(ctx context.Context, req *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
-	// currently there is a single NodeServer capability according to the spec
-	nscap := &csi.NodeServiceCapability{
-		Type: &csi.NodeServiceCapability_Rpc{
-			Rpc: &csi.NodeServiceCapability_RPC{
-				Type: csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME,
+	var caps []*csi.NodeServiceCapability
+	for _, c := range []csi.NodeServiceCapability_RPC_Type{
+		csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME,
+		csi.NodeServiceCapability_RPC_VOLUME_MOUNT_GROUP,
+		csi.NodeServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER,
+	} {
+		caps = append(caps, &csi.NodeServiceCapability{
+			Type: &csi.NodeServiceCapability_Rpc{
+				Rpc: &csi.NodeServiceCapability_RPC{
+					Type: c,
+				},
 			},
-		},
+		})
 	}
 
 	return &csi.NodeGetCapabilitiesResponse{
-		Capabilities: []*csi.NodeServiceCapability{
-			nscap,
-		},
+		Capabilities: caps,
 	}, nil
 }
 
+func (ns *nodeServer) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
+	return &csi.NodeGetInfoResponse{
+		NodeId: ns.nodeID,
+	}, nil
+}
+
+func (ns *nodeServer) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVolumeStatsRequest) (*csi.NodeGetVolumeStatsResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "NodeGetVolumeStats is not implemented")
+}
+
 func (ns *nodeServer) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandVolumeRequest) (*csi.NodeExpandVolumeResponse, error) {
 	return &csi.NodeExpandVolumeResponse{}, status.Error(codes.Unimplemented, "NodeExpandVolume is not implemented")
 }
 
+// checkMount checks if targetPath is a mountpoint and creates it if it doesn't
+// exist. Stale mounts whose FUSE process has died are unmounted.
 func checkMount(targetPath string) (bool, error) {
 	notMnt, err := mount.New("").IsLikelyNotMountPoint(targetPath)
+	if err != nil && mount.IsCorruptedMnt(err) {
+		logging.Warningf("Mountpoint %s is corrupted: %v, unmounting it", targetPath, err)
+		if err := mounter.ForceUnmount(targetPath); err != nil {
+			return false, err
+		}
+		notMnt, err = mount.New("").IsLikelyNotMountPoint(targetPath)
+	}
 	if err != nil {
 		if os.IsNotExist(err) {
 			if err = os.MkdirAll(targetPath, 0750); err != nil {
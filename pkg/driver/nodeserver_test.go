@@ -0,0 +1,524 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/kubernetes/pkg/util/mount"
+
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/mounter"
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/s3"
+)
+
+// fakeGeesefsScript mounts a tmpfs at the target, which is the last
+// argument, and stays in foreground until it's unmounted
+const fakeGeesefsScript = `#!/bin/sh
+for target; do :; done
+mount -t tmpfs none "$target" || exit 1
+while mountpoint -q "$target"; do sleep 0.1; done
+`
+
+// useFakeGeesefs makes geesefs mounts run fakeGeesefsScript and returns
+// a function restoring the settings. Tests using it are skipped if
+// mounting isn't permitted.
+func useFakeGeesefs(dir string) func() {
+	probe := filepath.Join(dir, "probe")
+	Expect(os.Mkdir(probe, 0755)).To(Succeed())
+	if err := exec.Command("mount", "-t", "tmpfs", "none", probe).Run(); err != nil {
+		Skip("mounting is not permitted: " + err.Error())
+	}
+	Expect(exec.Command("umount", probe).Run()).To(Succeed())
+	script := filepath.Join(dir, "geesefs")
+	Expect(ioutil.WriteFile(script, []byte(fakeGeesefsScript), 0755)).To(Succeed())
+	settings := mounter.DefaultSettings
+	settings.GeesefsPath = script
+	mounter.Configure(settings)
+	return func() {
+		mounter.Configure(mounter.DefaultSettings)
+	}
+}
+
+// fakeMounterType is a mounter registered for tests, which doesn't mount
+// anything and returns errors set by tests
+const fakeMounterType = "test-fake"
+
+type fakeMounter struct {
+	capabilities mounter.Capabilities
+	meta         *s3.FSMeta
+	unmountErr   error
+	statusErr    error
+	mounts       int
+	unmounts     int
+}
+
+// currentFakeMounter is returned by the factory of fakeMounterType
+var currentFakeMounter *fakeMounter
+
+func init() {
+	mounter.Register(fakeMounterType, func(meta *s3.FSMeta, cfg *s3.Config) (mounter.Mounter, error) {
+		currentFakeMounter.meta = meta
+		return currentFakeMounter, nil
+	}, mounter.CacheSizeKey)
+}
+
+func (m *fakeMounter) Mount(ctx context.Context, target, volumeID string) error {
+	m.mounts++
+	return nil
+}
+
+func (m *fakeMounter) Unmount(ctx context.Context, target, volumeID string) error {
+	m.unmounts++
+	return m.unmountErr
+}
+
+func (m *fakeMounter) Status(target, volumeID string) error {
+	return m.statusErr
+}
+
+func (m *fakeMounter) Capabilities() mounter.Capabilities {
+	return m.capabilities
+}
+
+// blockingMounter blocks Unmount until release is closed
+type blockingMounter struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (m *blockingMounter) Mount(ctx context.Context, target, volumeID string) error {
+	return nil
+}
+
+func (m *blockingMounter) Unmount(ctx context.Context, target, volumeID string) error {
+	close(m.started)
+	<-m.release
+	return nil
+}
+
+func (m *blockingMounter) Status(target, volumeID string) error {
+	return nil
+}
+
+func (m *blockingMounter) Capabilities() mounter.Capabilities {
+	return mounter.Capabilities{}
+}
+
+var _ = Describe("NodeUnstageVolume", func() {
+	const volumeID = "test-locks/pvc-1"
+	var ns *nodeServer
+	var staging string
+	var m *blockingMounter
+
+	BeforeEach(func() {
+		var err error
+		staging, err = ioutil.TempDir("", "staging")
+		Expect(err).NotTo(HaveOccurred())
+		m = &blockingMounter{started: make(chan struct{}), release: make(chan struct{})}
+		ns = &nodeServer{
+			mounters:    map[string]mounter.Mounter{staging: m},
+			statuses:    make(map[string]*volumeStatus),
+			volumeLocks: newOperationLocks(),
+			targetLocks: newOperationLocks(),
+		}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(staging)).To(Succeed())
+	})
+
+	unstage := func() error {
+		_, err := ns.NodeUnstageVolume(context.Background(), &csi.NodeUnstageVolumeRequest{
+			VolumeId:          volumeID,
+			StagingTargetPath: staging,
+		})
+		return err
+	}
+
+	It("aborts overlapping operations on the volume", func() {
+		unstageErr := make(chan error, 1)
+		go func() {
+			unstageErr <- unstage()
+		}()
+		Eventually(m.started).Should(BeClosed())
+
+		Expect(status.Code(unstage())).To(Equal(codes.Aborted))
+		_, err := ns.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+			VolumeId:          volumeID,
+			StagingTargetPath: staging,
+			VolumeCapability:  mountCapabilities[0],
+		})
+		Expect(status.Code(err)).To(Equal(codes.Aborted))
+
+		close(m.release)
+		Eventually(unstageErr).Should(Receive(BeNil()))
+		Expect(ns.mounters).To(BeEmpty())
+		Expect(ns.volumeLocks.keys).To(BeEmpty())
+	})
+})
+
+var _ = Describe("reportStatus", func() {
+	const bucket = "test-node-status"
+	var ns *nodeServer
+	var cs *controllerServer
+
+	BeforeEach(func() {
+		ns = &nodeServer{
+			nodeID:         "node-1",
+			reportStatuses: true,
+			statuses:       make(map[string]*volumeStatus),
+		}
+		cs = newTestControllerServer()
+		_, err := cs.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+			Name:               "pvc-1",
+			CapacityRange:      &csi.CapacityRange{RequiredBytes: 1 << 30},
+			VolumeCapabilities: mountCapabilities,
+			Parameters:         map[string]string{"bucket": bucket},
+			Secrets:            testSecrets(),
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		_, err := cs.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{
+			VolumeId: bucket + "/pvc-1",
+			Secrets:  testSecrets(),
+		})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	readNodes := func(prefix string) []s3.NodeStatus {
+		client, err := s3.NewClientFromSecret(testSecrets())
+		Expect(err).NotTo(HaveOccurred())
+		_, nodes, err := client.ReadVolumeMeta(bucket, prefix)
+		Expect(err).NotTo(HaveOccurred())
+		return nodes
+	}
+
+	It("writes and removes statuses of volumes with metadata", func() {
+		ns.reportStatus(context.Background(), bucket+"/pvc-1", testSecrets(), nil)
+		Expect(ns.statuses).To(HaveKey(bucket + "/pvc-1"))
+		nodes := readNodes("pvc-1")
+		Expect(nodes).To(HaveLen(1))
+		Expect(nodes[0].NodeID).To(Equal("node-1"))
+		Expect(nodes[0].Abnormal).To(BeFalse())
+
+		ns.clearStatus(context.Background(), bucket+"/pvc-1")
+		Expect(ns.statuses).To(BeEmpty())
+		Expect(readNodes("pvc-1")).To(BeEmpty())
+	})
+
+	It("doesn't write statuses if it's disabled", func() {
+		ns.reportStatuses = false
+		ns.reportStatus(context.Background(), bucket+"/pvc-1", testSecrets(), nil)
+		Expect(ns.statuses).To(BeEmpty())
+		Expect(readNodes("pvc-1")).To(BeEmpty())
+	})
+
+	It("skips volumes without metadata", func() {
+		ns.reportStatus(context.Background(), bucket+"/static", testSecrets(), nil)
+		Expect(ns.statuses).To(BeEmpty())
+		Expect(readNodes("static")).To(BeEmpty())
+	})
+
+	It("doesn't keep statuses which can't be written", func() {
+		secrets := testSecrets()
+		secrets["endpoint"] = "http://127.0.0.1:1"
+		ns.reportStatus(context.Background(), bucket+"/pvc-1", secrets, nil)
+		Expect(ns.statuses).To(BeEmpty())
+		Expect(readNodes("pvc-1")).To(BeEmpty())
+	})
+})
+
+var _ = Describe("Ephemeral volumes", func() {
+	publish := func(attrib map[string]string) error {
+		attrib[ephemeralKey] = "true"
+		ns := &nodeServer{targetLocks: newOperationLocks()}
+		_, err := ns.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+			VolumeId:         "csi-ephemeral",
+			TargetPath:       "/nonexistent/target",
+			VolumeCapability: mountCapabilities[0],
+			VolumeContext:    attrib,
+			Secrets:          testSecrets(),
+		})
+		return err
+	}
+
+	table.DescribeTable("reject attributes which pods aren't allowed to set",
+		func(attrib map[string]string, message string) {
+			err := publish(attrib)
+			Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+			Expect(status.Convert(err).Message()).To(Equal(message))
+		},
+		table.Entry("options", map[string]string{"bucket": "b", "options": "--config /etc/rclone.conf"},
+			"Attributes options are not allowed in ephemeral volumes"),
+		table.Entry("mounter", map[string]string{"bucket": "b", "mounter": "s3fs"},
+			"Attributes mounter are not allowed in ephemeral volumes"),
+		table.Entry("unknown keys", map[string]string{"bucket": "b", "passwd_file": "/etc/shadow", "capacity": "1Gi"},
+			"Attributes capacity, passwd_file are not allowed in ephemeral volumes"),
+	)
+
+	Context("with a mounter", func() {
+		var dir, target string
+		var restore func()
+		var ns *nodeServer
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "ephemeral")
+			Expect(err).NotTo(HaveOccurred())
+			restore = useFakeGeesefs(dir)
+			target = filepath.Join(dir, "target")
+			ns = &nodeServer{
+				mounters:    make(map[string]mounter.Mounter),
+				targetLocks: newOperationLocks(),
+			}
+		})
+
+		AfterEach(func() {
+			restore()
+			exec.Command("umount", target).Run()
+			Expect(os.RemoveAll(dir)).To(Succeed())
+		})
+
+		It("mounts the volume at the target and unmounts it", func() {
+			req := &csi.NodePublishVolumeRequest{
+				VolumeId:         "csi-ephemeral",
+				TargetPath:       target,
+				VolumeCapability: mountCapabilities[0],
+				VolumeContext:    map[string]string{ephemeralKey: "true", "bucket": "b", "prefix": "p"},
+				Secrets:          testSecrets(),
+			}
+			_, err := ns.NodePublishVolume(context.Background(), req)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ns.mounters).To(HaveKey(target))
+			notMnt, err := checkMount(target)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(notMnt).To(BeFalse())
+
+			// Publishing again is a no-op
+			_, err = ns.NodePublishVolume(context.Background(), req)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = ns.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{
+				VolumeId:   "csi-ephemeral",
+				TargetPath: target,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ns.mounters).To(BeEmpty())
+			Expect(target).NotTo(BeADirectory())
+		})
+
+		It("requires credentials", func() {
+			_, err := ns.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+				VolumeId:         "csi-ephemeral",
+				TargetPath:       target,
+				VolumeCapability: mountCapabilities[0],
+				VolumeContext:    map[string]string{ephemeralKey: "true", "bucket": "b"},
+			})
+			Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+			Expect(target).NotTo(BeADirectory())
+		})
+	})
+
+	It("accepts typed parameters and attributes set by kubelet", func() {
+		Expect(checkEphemeralAttributes(map[string]string{
+			"bucket":                           "b",
+			"prefix":                           "p",
+			"memoryLimit":                      "1000",
+			"uid":                              "1000",
+			"dirMode":                          "0755",
+			"unmountTimeout":                   "1m",
+			"csi.storage.k8s.io/ephemeral":     "true",
+			"csi.storage.k8s.io/pod.name":      "pod",
+			"csi.storage.k8s.io/pod.namespace": "default",
+		})).To(Succeed())
+	})
+})
+
+var _ = Describe("Volume mounters", func() {
+	const volumeID = "bucket/pvc-1"
+	var dir, target string
+	var ns *nodeServer
+	var m *fakeMounter
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "mounters")
+		Expect(err).NotTo(HaveOccurred())
+		target = filepath.Join(dir, "target")
+		Expect(os.Mkdir(target, 0750)).To(Succeed())
+		m = &fakeMounter{}
+		currentFakeMounter = m
+		ns = &nodeServer{
+			mounters: make(map[string]mounter.Mounter),
+			cache:    mounter.NewCache(filepath.Join(dir, "cache")),
+		}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	mountVolume := func(readOnly bool, params map[string]string) error {
+		volumeContext := map[string]string{"mounter": fakeMounterType}
+		for k, v := range params {
+			volumeContext[k] = v
+		}
+		return ns.mountVolume(context.Background(), volumeID, target, "bucket", "pvc-1", volumeContext,
+			mountCapabilities[0], readOnly, testSecrets())
+	}
+
+	It("rejects read-only mounts if the mounter can't do them", func() {
+		err := mountVolume(true, nil)
+		Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+		Expect(status.Convert(err).Message()).To(Equal("Mounter test-fake can't mount volumes read-only"))
+		Expect(m.mounts).To(BeZero())
+		Expect(ns.mounters).To(BeEmpty())
+
+		m.capabilities.ReadOnly = true
+		Expect(mountVolume(true, nil)).To(Succeed())
+		Expect(m.meta.ReadOnly).To(BeTrue())
+		Expect(ns.mounters).To(HaveKey(target))
+	})
+
+	It("prepares the disk cache only if the mounter supports it", func() {
+		Expect(mountVolume(false, map[string]string{"cacheSize": "1Gi"})).To(Succeed())
+		Expect(m.meta.CacheDir).To(BeEmpty())
+
+		m.capabilities.Cache = true
+		Expect(mountVolume(false, map[string]string{"cacheSize": "1Gi"})).To(Succeed())
+		Expect(m.meta.CacheDir).To(BeADirectory())
+	})
+
+	It("unmounts the volume with its mounter and removes the cache", func() {
+		m.capabilities.Cache = true
+		Expect(mountVolume(false, map[string]string{"cacheSize": "1Gi"})).To(Succeed())
+		cacheDir := m.meta.CacheDir
+		Expect(ns.unmountVolume(context.Background(), volumeID, target)).To(Succeed())
+		Expect(m.unmounts).To(Equal(1))
+		Expect(ns.mounters).To(BeEmpty())
+		Expect(cacheDir).NotTo(BeADirectory())
+	})
+
+	It("keeps the volume if its mount is alive after an unmount error", func() {
+		Expect(mountVolume(false, nil)).To(Succeed())
+		m.unmountErr = errors.New("busy")
+		Expect(ns.unmountVolume(context.Background(), volumeID, target)).To(MatchError("busy"))
+		Expect(ns.mounters).To(HaveKey(target))
+	})
+
+	It("forgets the volume if it isn't mounted anymore", func() {
+		Expect(mountVolume(false, nil)).To(Succeed())
+		m.unmountErr = errors.New("not mounted")
+		m.statusErr = errors.New("not mounted")
+		Expect(ns.unmountVolume(context.Background(), volumeID, target)).To(Succeed())
+		Expect(ns.mounters).To(BeEmpty())
+	})
+
+	It("remounts a staged volume which isn't available before publishing", func() {
+		staging := filepath.Join(dir, "staging")
+		Expect(os.Mkdir(staging, 0750)).To(Succeed())
+		if err := exec.Command("mount", "--bind", staging, target).Run(); err != nil {
+			Skip("mounting is not permitted: " + err.Error())
+		}
+		Expect(exec.Command("umount", target).Run()).To(Succeed())
+		m.statusErr = errors.New("not mounted")
+		ns.mounters[staging] = m
+		ns.volumeLocks = newOperationLocks()
+		ns.targetLocks = newOperationLocks()
+		_, err := ns.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+			VolumeId:          volumeID,
+			StagingTargetPath: staging,
+			TargetPath:        target,
+			VolumeCapability:  mountCapabilities[0],
+			VolumeContext:     map[string]string{"mounter": fakeMounterType},
+			Secrets:           testSecrets(),
+		})
+		Expect(err).NotTo(HaveOccurred())
+		defer exec.Command("umount", target).Run()
+		Expect(m.mounts).To(Equal(1))
+		// The staging directory is bind-mounted at the target
+		Expect(ioutil.WriteFile(filepath.Join(staging, "file"), nil, 0644)).To(Succeed())
+		Expect(filepath.Join(target, "file")).To(BeAnExistingFile())
+	})
+})
+
+// mountDeadFuse mounts a FUSE file system at target and closes its
+// connection, like a FUSE process which has crashed. Tests using it are
+// skipped if mounting isn't permitted.
+func mountDeadFuse(target string) {
+	fuse, err := os.OpenFile("/dev/fuse", os.O_RDWR, 0)
+	if err != nil {
+		Skip("FUSE is not available: " + err.Error())
+	}
+	defer fuse.Close()
+	opts := fmt.Sprintf("fd=%d,rootmode=40000,user_id=0,group_id=0", fuse.Fd())
+	if err := syscall.Mount("test", target, "fuse.test", 0, opts); err != nil {
+		Skip("mounting is not permitted: " + err.Error())
+	}
+}
+
+var _ = Describe("Stale mounts", func() {
+	const volumeID = "bucket/pvc-1"
+	var dir, target string
+	var ns *nodeServer
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "stale")
+		Expect(err).NotTo(HaveOccurred())
+		target = filepath.Join(dir, "target")
+		Expect(os.Mkdir(target, 0750)).To(Succeed())
+		mountDeadFuse(target)
+		_, err = os.Stat(target)
+		Expect(mount.IsCorruptedMnt(err)).To(BeTrue())
+		ns = &nodeServer{
+			mounters:    make(map[string]mounter.Mounter),
+			targetLocks: newOperationLocks(),
+		}
+	})
+
+	AfterEach(func() {
+		syscall.Unmount(target, syscall.MNT_DETACH)
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("unmounts them when checking mountpoints", func() {
+		notMnt, err := checkMount(target)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(notMnt).To(BeTrue())
+		Expect(target).To(BeADirectory())
+	})
+
+	It("unpublishes them", func() {
+		_, err := ns.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{
+			VolumeId:   volumeID,
+			TargetPath: target,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(target).NotTo(BeAnExistingFile())
+	})
+
+	It("force unmounts them if the mounter fails", func() {
+		m := &fakeMounter{unmountErr: errors.New("not connected"), statusErr: errors.New("not connected")}
+		ns.mounters[target] = m
+		Expect(ns.unmountVolume(context.Background(), volumeID, target)).To(Succeed())
+		Expect(m.unmounts).To(Equal(1))
+		Expect(ns.mounters).To(BeEmpty())
+		notMnt, err := mount.New("").IsLikelyNotMountPoint(target)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(notMnt).To(BeTrue())
+	})
+})
@@ -0,0 +1,194 @@
+package driver
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/golang/protobuf/proto"
+	"github.com/kubernetes-csi/csi-lib-utils/protosanitizer"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/logging"
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/metrics"
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/tracing"
+)
+
+// Time for RPCs to return after they are aborted on shutdown
+const abortTimeout = 5 * time.Second
+
+// grpcServer is a non-blocking gRPC server with
+// interceptors recording traces and metrics of RPC calls
+type grpcServer struct {
+	wg     sync.WaitGroup
+	server *grpc.Server
+}
+
+func newGRPCServer() *grpcServer {
+	return &grpcServer{}
+}
+
+// Start starts serving at the endpoint in background
+func (s *grpcServer) Start(endpoint string, ids csi.IdentityServer, cs csi.ControllerServer, ns csi.NodeServer) {
+	proto, addr, err := parseEndpoint(endpoint)
+	if err != nil {
+		logging.Fatal(err.Error())
+	}
+
+	if proto == "unix" {
+		if err := os.Remove(addr); err != nil && !os.IsNotExist(err) {
+			logging.Fatalf("Failed to remove %s, error: %s", addr, err.Error())
+		}
+	}
+
+	listener, err := net.Listen(proto, addr)
+	if err != nil {
+		logging.Fatalf("Failed to listen: %v", err)
+	}
+
+	s.server = grpc.NewServer(grpc.ChainUnaryInterceptor(
+		otelgrpc.UnaryServerInterceptor(),
+		logGRPC,
+		metrics.UnaryInterceptor,
+	))
+	if ids != nil {
+		csi.RegisterIdentityServer(s.server, ids)
+	}
+	if cs != nil {
+		csi.RegisterControllerServer(s.server, cs)
+	}
+	if ns != nil {
+		csi.RegisterNodeServer(s.server, ns)
+	}
+
+	logging.Infof("Listening for connections on address: %#v", listener.Addr())
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		if err := s.server.Serve(listener); err != nil {
+			logging.Errorf("Error serving CSI endpoint: %v", err)
+		}
+	}()
+}
+
+// parseEndpoint splits unix://<path> and tcp://<address> endpoints into
+// the network and the address. Paths of unix sockets are absolute, so
+// unix://tmp/csi.sock is /tmp/csi.sock.
+func parseEndpoint(endpoint string) (string, string, error) {
+	s := strings.SplitN(endpoint, "://", 2)
+	if len(s) == 2 && s[1] != "" {
+		switch proto := strings.ToLower(s[0]); proto {
+		case "unix":
+			return proto, "/" + strings.TrimLeft(s[1], "/"), nil
+		case "tcp":
+			return proto, s[1], nil
+		}
+	}
+	return "", "", fmt.Errorf("Invalid endpoint: %v", endpoint)
+}
+
+// Wait waits for the server to stop
+func (s *grpcServer) Wait() {
+	s.wg.Wait()
+}
+
+// Stop stops accepting new RPCs and waits up to timeout for running ones.
+// Then abort is called to cancel long operations, which get abortTimeout
+// to return before the server is stopped forcibly.
+func (s *grpcServer) Stop(timeout time.Duration, abort func()) {
+	stopped := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return
+	case <-time.After(timeout):
+	}
+	logging.Warningf("Running RPCs didn't finish in %v, aborting them", timeout)
+	abort()
+	select {
+	case <-stopped:
+	case <-time.After(abortTimeout):
+		logging.Warningf("Running RPCs didn't abort in %v, stopping", abortTimeout)
+		s.server.Stop()
+	}
+}
+
+// logGRPC logs RPC calls and adds a logger with fields
+// of the call to the context of the handler
+func logGRPC(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	log := logging.FromContext(ctx).With("rpc", info.FullMethod)
+	if id := tracing.TraceID(ctx); id != "" {
+		log = log.With("trace_id", id)
+	}
+	if r, ok := req.(interface{ GetVolumeId() string }); ok && r.GetVolumeId() != "" {
+		log = log.With("volume_id", r.GetVolumeId())
+	}
+	ctx = logging.NewContext(ctx, log)
+	log.V(3).Info("GRPC call")
+	log.V(5).Infof("GRPC request: %s", redactMessage(req))
+	resp, err := handler(ctx, req)
+	if err != nil {
+		log.Errorf("GRPC error: %v", err)
+	} else {
+		log.V(5).Infof("GRPC response: %s", redactMessage(resp))
+	}
+	return resp, err
+}
+
+// redactMessage formats a request or a response for logs. Secrets are stripped
+// and maps like volume contexts and parameters are redacted, as they may hold
+// credentials in mount options.
+func redactMessage(msg interface{}) fmt.Stringer {
+	if m, ok := msg.(proto.Message); ok && m != nil {
+		m = proto.Clone(m)
+		redactMaps(proto.MessageReflect(m))
+		msg = m
+	}
+	return protosanitizer.StripSecrets(msg)
+}
+
+// redactMaps redacts string maps of a message and its nested messages in place
+func redactMaps(m protoreflect.Message) {
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case fd.IsMap():
+			if fd.MapKey().Kind() == protoreflect.StringKind && fd.MapValue().Kind() == protoreflect.StringKind {
+				mp := m.Mutable(fd).Map()
+				values := map[string]string{}
+				mp.Range(func(k protoreflect.MapKey, v protoreflect.Value) bool {
+					values[k.String()] = v.String()
+					return true
+				})
+				for k, v := range logging.RedactMap(values) {
+					mp.Set(protoreflect.ValueOfString(k).MapKey(), protoreflect.ValueOfString(v))
+				}
+			} else if fd.MapValue().Message() != nil {
+				m.Mutable(fd).Map().Range(func(k protoreflect.MapKey, v protoreflect.Value) bool {
+					redactMaps(v.Message())
+					return true
+				})
+			}
+		case fd.IsList():
+			if fd.Message() != nil {
+				list := m.Mutable(fd).List()
+				for i := 0; i < list.Len(); i++ {
+					redactMaps(list.Get(i).Message())
+				}
+			}
+		case fd.Message() != nil:
+			redactMaps(m.Mutable(fd).Message())
+		}
+		return true
+	})
+}
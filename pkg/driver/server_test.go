@@ -0,0 +1,107 @@
+package driver
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+)
+
+// blockingIdentityServer blocks Probe until release is closed
+type blockingIdentityServer struct {
+	csi.UnimplementedIdentityServer
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingIdentityServer) Probe(ctx context.Context, req *csi.ProbeRequest) (*csi.ProbeResponse, error) {
+	close(s.started)
+	<-s.release
+	return &csi.ProbeResponse{}, nil
+}
+
+var _ = Describe("grpcServer", func() {
+	var dir string
+	var s *grpcServer
+	var ids *blockingIdentityServer
+	var probeErr chan error
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "grpc-server")
+		Expect(err).NotTo(HaveOccurred())
+		socket := filepath.Join(dir, "csi.sock")
+		ids = &blockingIdentityServer{started: make(chan struct{}), release: make(chan struct{})}
+		s = newGRPCServer()
+		s.Start("unix://"+socket, ids, nil, nil)
+
+		conn, err := grpc.Dial("unix://"+socket, grpc.WithInsecure())
+		Expect(err).NotTo(HaveOccurred())
+		probeErr = make(chan error, 1)
+		go func() {
+			defer conn.Close()
+			_, err := csi.NewIdentityClient(conn).Probe(context.Background(), &csi.ProbeRequest{})
+			probeErr <- err
+		}()
+		Eventually(ids.started, 5*time.Second).Should(BeClosed())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("waits for running RPCs on stop", func() {
+		aborted := false
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			close(ids.release)
+		}()
+		s.Stop(5*time.Second, func() { aborted = true })
+		s.Wait()
+		Expect(aborted).To(BeFalse())
+		Expect(<-probeErr).NotTo(HaveOccurred())
+	})
+
+	It("aborts RPCs which don't finish in time", func() {
+		s.Stop(100*time.Millisecond, func() { close(ids.release) })
+		s.Wait()
+		Expect(<-probeErr).NotTo(HaveOccurred())
+	})
+})
+
+var _ = Describe("redactMessage", func() {
+	It("redacts volume contexts and parameters", func() {
+		req := &csi.NodePublishVolumeRequest{
+			VolumeId:      "bucket/prefix",
+			VolumeContext: map[string]string{"options": "--sse-c=abc --uid=1000", "capacity": "1Gi"},
+			Secrets:       map[string]string{"secretAccessKey": "xyz"},
+		}
+		logged := redactMessage(req).String()
+		Expect(logged).To(ContainSubstring("--sse-c=***"))
+		Expect(logged).To(ContainSubstring("--uid=1000"))
+		Expect(logged).To(ContainSubstring("1Gi"))
+		Expect(logged).NotTo(ContainSubstring("abc"))
+		Expect(logged).NotTo(ContainSubstring("xyz"))
+		Expect(req.VolumeContext["options"]).To(Equal("--sse-c=abc --uid=1000"))
+	})
+
+	It("redacts maps of nested messages", func() {
+		resp := &csi.ListVolumesResponse{Entries: []*csi.ListVolumesResponse_Entry{{
+			Volume: &csi.Volume{VolumeId: "bucket", VolumeContext: map[string]string{"accessKeyID": "abc"}},
+		}}}
+		logged := redactMessage(resp).String()
+		Expect(logged).To(ContainSubstring("bucket"))
+		Expect(logged).NotTo(ContainSubstring("abc"))
+	})
+
+	It("formats nil responses", func() {
+		var resp *csi.CreateVolumeResponse
+		Expect(redactMessage(resp).String()).To(Equal("null"))
+	})
+})
@@ -0,0 +1,269 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Output formats
+const (
+	// FormatText writes lines through glog with fields appended as key=value
+	FormatText = "text"
+	// FormatJSON writes a JSON object per line to stderr
+	FormatJSON = "json"
+)
+
+var (
+	outMu      sync.Mutex
+	out        io.Writer = os.Stderr
+	jsonFormat bool
+	// Fields added to every line
+	globalFields []interface{}
+)
+
+// Init selects the output format and sets fields added to every line
+func Init(format string, fields ...interface{}) error {
+	switch format {
+	case FormatText, "":
+		jsonFormat = false
+	case FormatJSON:
+		jsonFormat = true
+	default:
+		return fmt.Errorf("unknown log format %q, must be %s or %s", format, FormatText, FormatJSON)
+	}
+	globalFields = fields
+	return nil
+}
+
+// Logger writes log lines with structured fields. Values of fields with
+// credential-like keys are redacted. The zero Logger has no fields.
+type Logger struct {
+	fields []interface{}
+}
+
+// With returns a logger which adds key/value pairs to every line
+func With(keysAndValues ...interface{}) Logger {
+	return Logger{}.With(keysAndValues...)
+}
+
+// With returns a copy of the logger with more key/value pairs
+func (l Logger) With(keysAndValues ...interface{}) Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(keysAndValues))
+	fields = append(fields, l.fields...)
+	return Logger{fields: append(fields, keysAndValues...)}
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying the logger
+func NewContext(ctx context.Context, l Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the logger of the context, or a logger without fields
+func FromContext(ctx context.Context) Logger {
+	if l, ok := ctx.Value(contextKey{}).(Logger); ok {
+		return l
+	}
+	return Logger{}
+}
+
+type severity int
+
+const (
+	infoLog severity = iota
+	warningLog
+	errorLog
+	fatalLog
+)
+
+var severityNames = []string{"info", "warning", "error", "fatal"}
+
+// Verbose logs info lines only if the verbosity level is enabled with -v
+type Verbose struct {
+	enabled bool
+	level   glog.Level
+	logger  Logger
+}
+
+// V returns a Verbose which logs only if -v is at least level
+func (l Logger) V(level glog.Level) Verbose {
+	return Verbose{enabled: bool(glog.V(level)), level: level, logger: l}
+}
+
+func (v Verbose) Info(args ...interface{}) {
+	if v.enabled {
+		v.logger.output(2, infoLog, v.level, fmt.Sprint(args...))
+	}
+}
+
+func (v Verbose) Infof(format string, args ...interface{}) {
+	if v.enabled {
+		v.logger.output(2, infoLog, v.level, fmt.Sprintf(format, args...))
+	}
+}
+
+func (l Logger) Info(args ...interface{}) {
+	l.output(2, infoLog, 0, fmt.Sprint(args...))
+}
+
+func (l Logger) Infof(format string, args ...interface{}) {
+	l.output(2, infoLog, 0, fmt.Sprintf(format, args...))
+}
+
+func (l Logger) Warning(args ...interface{}) {
+	l.output(2, warningLog, 0, fmt.Sprint(args...))
+}
+
+func (l Logger) Warningf(format string, args ...interface{}) {
+	l.output(2, warningLog, 0, fmt.Sprintf(format, args...))
+}
+
+func (l Logger) Error(args ...interface{}) {
+	l.output(2, errorLog, 0, fmt.Sprint(args...))
+}
+
+func (l Logger) Errorf(format string, args ...interface{}) {
+	l.output(2, errorLog, 0, fmt.Sprintf(format, args...))
+}
+
+func (l Logger) Fatal(args ...interface{}) {
+	l.output(2, fatalLog, 0, fmt.Sprint(args...))
+}
+
+func (l Logger) Fatalf(format string, args ...interface{}) {
+	l.output(2, fatalLog, 0, fmt.Sprintf(format, args...))
+}
+
+func (l Logger) Fatalln(args ...interface{}) {
+	l.output(2, fatalLog, 0, fmt.Sprint(args...))
+}
+
+// Package-level functions log without fields like glog
+
+func V(level glog.Level) Verbose {
+	return Logger{}.V(level)
+}
+
+func Info(args ...interface{}) {
+	Logger{}.output(2, infoLog, 0, fmt.Sprint(args...))
+}
+
+func Infof(format string, args ...interface{}) {
+	Logger{}.output(2, infoLog, 0, fmt.Sprintf(format, args...))
+}
+
+func Warning(args ...interface{}) {
+	Logger{}.output(2, warningLog, 0, fmt.Sprint(args...))
+}
+
+func Warningf(format string, args ...interface{}) {
+	Logger{}.output(2, warningLog, 0, fmt.Sprintf(format, args...))
+}
+
+func Error(args ...interface{}) {
+	Logger{}.output(2, errorLog, 0, fmt.Sprint(args...))
+}
+
+func Errorf(format string, args ...interface{}) {
+	Logger{}.output(2, errorLog, 0, fmt.Sprintf(format, args...))
+}
+
+func Fatal(args ...interface{}) {
+	Logger{}.output(2, fatalLog, 0, fmt.Sprint(args...))
+}
+
+func Fatalf(format string, args ...interface{}) {
+	Logger{}.output(2, fatalLog, 0, fmt.Sprintf(format, args...))
+}
+
+func Fatalln(args ...interface{}) {
+	Logger{}.output(2, fatalLog, 0, fmt.Sprint(args...))
+}
+
+// output writes a line, depth is the number of stack frames
+// between the caller of the logging function and output
+func (l Logger) output(depth int, s severity, level glog.Level, msg string) {
+	if jsonFormat {
+		l.writeJSON(depth+1, s, level, msg)
+		if s == fatalLog {
+			os.Exit(255)
+		}
+		return
+	}
+	var buf bytes.Buffer
+	buf.WriteString(msg)
+	writeTextFields(&buf, globalFields)
+	writeTextFields(&buf, l.fields)
+	line := buf.String()
+	switch s {
+	case infoLog:
+		glog.InfoDepth(depth, line)
+	case warningLog:
+		glog.WarningDepth(depth, line)
+	case errorLog:
+		glog.ErrorDepth(depth, line)
+	case fatalLog:
+		glog.FatalDepth(depth, line)
+	}
+}
+
+func writeTextFields(buf *bytes.Buffer, fields []interface{}) {
+	for i := 0; i < len(fields); i += 2 {
+		key, value := field(fields, i)
+		fmt.Fprintf(buf, " %s=%v", key, value)
+	}
+}
+
+func (l Logger) writeJSON(depth int, s severity, level glog.Level, msg string) {
+	line := make(map[string]interface{}, 6+(len(globalFields)+len(l.fields))/2)
+	for _, fields := range [][]interface{}{globalFields, l.fields} {
+		for i := 0; i < len(fields); i += 2 {
+			key, value := field(fields, i)
+			if err, ok := value.(error); ok {
+				value = err.Error()
+			}
+			line[key] = value
+		}
+	}
+	line["ts"] = time.Now().UTC().Format(time.RFC3339Nano)
+	line["level"] = severityNames[s]
+	if level > 0 {
+		line["v"] = level
+	}
+	if _, file, n, ok := runtime.Caller(depth); ok {
+		line["caller"] = fmt.Sprintf("%s:%d", filepath.Base(file), n)
+	}
+	line["msg"] = msg
+	buf, err := json.Marshal(line)
+	if err != nil {
+		buf, _ = json.Marshal(map[string]interface{}{
+			"ts":    line["ts"],
+			"level": line["level"],
+			"msg":   msg,
+			"error": fmt.Sprintf("Error encoding log fields: %v", err),
+		})
+	}
+	outMu.Lock()
+	defer outMu.Unlock()
+	out.Write(append(buf, '\n'))
+}
+
+// field returns the i-th key/value pair of fields with a redacted value
+func field(fields []interface{}, i int) (string, interface{}) {
+	key := fmt.Sprint(fields[i])
+	if i+1 >= len(fields) {
+		return key, "(MISSING)"
+	}
+	return key, redactValue(key, fields[i+1])
+}
@@ -0,0 +1,116 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestLogging(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Logging")
+}
+
+var _ = Describe("Redaction", func() {
+	It("redacts credential options in args", func() {
+		Expect(RedactArgs([]string{
+			"--endpoint", "https://storage.yandexcloud.net",
+			"--s3-secret-access-key=abc",
+			"--token", "def",
+			"-o", "allow_other,passwd=ghi",
+			"AWS_SECRET_ACCESS_KEY=jkl",
+			"--shared-config", "bucket:prefix", "/mnt/target",
+		})).To(Equal([]string{
+			"--endpoint", "https://storage.yandexcloud.net",
+			"--s3-secret-access-key=***",
+			"--token", "***",
+			"-o", "allow_other,passwd=***",
+			"AWS_SECRET_ACCESS_KEY=***",
+			"--shared-config", "bucket:prefix", "/mnt/target",
+		}))
+	})
+
+	It("redacts encryption keys but not their IDs", func() {
+		Expect(RedactArgs([]string{
+			"--sse-c", "abc",
+			"--sse-customer-key=def",
+			"--sse-kms-key-id", "key-1",
+			"-o", "use_sse=1,ssec=ghi",
+			"SSE_CUSTOMER_KEY=jkl",
+			"--kms-key-id=key-2",
+		})).To(Equal([]string{
+			"--sse-c", "***",
+			"--sse-customer-key=***",
+			"--sse-kms-key-id", "key-1",
+			"-o", "use_sse=1,ssec=***",
+			"SSE_CUSTOMER_KEY=***",
+			"--kms-key-id=key-2",
+		}))
+		Expect(IsSensitive("encryptionKey")).To(BeTrue())
+		Expect(IsSensitive("sse-kms-key-id")).To(BeFalse())
+		Expect(IsSensitive("sse-customer-algorithm")).To(BeFalse())
+	})
+
+	It("redacts volume context", func() {
+		Expect(RedactMap(map[string]string{
+			"bucket":    "bucket",
+			"secretKey": "abc",
+			"options":   "--memory-limit 1000 --session-token=def",
+			"capacity":  "1073741824",
+		})).To(Equal(map[string]string{
+			"bucket":    "bucket",
+			"secretKey": "***",
+			"options":   "--memory-limit 1000 --session-token=***",
+			"capacity":  "1073741824",
+		}))
+	})
+})
+
+var _ = Describe("JSON output", func() {
+	var buf *bytes.Buffer
+
+	BeforeEach(func() {
+		buf = &bytes.Buffer{}
+		out = buf
+		Expect(Init(FormatJSON, "node_id", "node-1")).To(Succeed())
+	})
+
+	AfterEach(func() {
+		out = os.Stderr
+		Expect(Init(FormatText)).To(Succeed())
+	})
+
+	It("writes fields as JSON and redacts them", func() {
+		With("volume_id", "vol-1", "rpc", "/csi.v1.Node/NodePublishVolume").
+			With("attributes", map[string]string{"accessKeyID": "abc"}, "secret", "def", "error", errors.New("failed")).
+			Warningf("Mounting %s", "vol-1")
+
+		var line map[string]interface{}
+		Expect(json.Unmarshal(buf.Bytes(), &line)).To(Succeed())
+		Expect(line).To(HaveKeyWithValue("level", "warning"))
+		Expect(line).To(HaveKeyWithValue("msg", "Mounting vol-1"))
+		Expect(line).To(HaveKeyWithValue("node_id", "node-1"))
+		Expect(line).To(HaveKeyWithValue("volume_id", "vol-1"))
+		Expect(line).To(HaveKeyWithValue("rpc", "/csi.v1.Node/NodePublishVolume"))
+		Expect(line).To(HaveKeyWithValue("attributes", map[string]interface{}{"accessKeyID": "***"}))
+		Expect(line).To(HaveKeyWithValue("secret", "***"))
+		Expect(line).To(HaveKeyWithValue("error", "failed"))
+		Expect(line).To(HaveKeyWithValue("caller", HavePrefix("logging_test.go:")))
+	})
+
+	It("takes the logger from context", func() {
+		ctx := NewContext(context.Background(), With("volume_id", "vol-2"))
+		FromContext(ctx).Info("Unmounted")
+		Expect(buf.String()).To(ContainSubstring(`"volume_id":"vol-2"`))
+	})
+
+	It("rejects unknown formats", func() {
+		Expect(Init("xml")).NotTo(Succeed())
+	})
+})
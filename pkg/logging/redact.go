@@ -0,0 +1,147 @@
+package logging
+
+import (
+	"strings"
+)
+
+// Redacted replaces values of credentials in logs
+const Redacted = "***"
+
+// Parts of names of fields, options and environment variables holding credentials
+var sensitiveWords = []string{
+	"secret",
+	"password",
+	"passwd",
+	"token",
+	"credential",
+	"accesskey",
+	"access_key",
+	"access-key",
+	"private",
+}
+
+// Names of SSE-C options holding encryption keys, like "--sse-c" of geesefs
+var sensitiveNames = []string{
+	"sse-c",
+	"sse_c",
+	"ssec",
+}
+
+// IsSensitive reports whether a name of a field, option or environment variable looks like a credential
+func IsSensitive(name string) bool {
+	name = strings.ToLower(name)
+	for _, word := range sensitiveWords {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+	for _, sensitive := range sensitiveNames {
+		if name == sensitive || strings.HasSuffix(name, "-"+sensitive) || strings.HasSuffix(name, "_"+sensitive) {
+			return true
+		}
+	}
+	// Keys like "sse-customer-key" are, IDs of keys like "sse-kms-key-id" aren't
+	return strings.HasSuffix(name, "key")
+}
+
+// RedactArgs returns a copy of command line arguments with values of credential-like
+// options replaced: "--opt=value", "--opt value", "-o key=value,..." and "KEY=value"
+func RedactArgs(args []string) []string {
+	res := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if strings.HasPrefix(arg, "-") {
+			name := strings.TrimLeft(arg, "-")
+			if eq := strings.IndexByte(name, '='); eq >= 0 {
+				if IsSensitive(name[:eq]) {
+					arg = arg[:len(arg)-len(name)+eq+1] + Redacted
+				}
+				res = append(res, arg)
+				continue
+			}
+			if i+1 < len(args) && !strings.HasPrefix(args[i+1], "-") {
+				if IsSensitive(name) {
+					res = append(res, arg, Redacted)
+					i++
+					continue
+				}
+				if arg == "-o" {
+					res = append(res, arg, redactOptions(args[i+1]))
+					i++
+					continue
+				}
+			}
+			res = append(res, arg)
+			continue
+		}
+		res = append(res, redactOptions(arg))
+	}
+	return res
+}
+
+// RedactMap returns a copy of a map like a volume context with values of
+// credential-like keys replaced, mount options in values are redacted too
+func RedactMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	res := make(map[string]string, len(m))
+	for k, v := range m {
+		if IsSensitive(k) {
+			v = Redacted
+		} else if strings.Contains(v, "=") || strings.HasPrefix(v, "-") {
+			v = redactString(v)
+		}
+		res[k] = v
+	}
+	return res
+}
+
+// redactString redacts options in a space-separated list of arguments
+func redactString(s string) string {
+	args := strings.Fields(s)
+	redacted := RedactArgs(args)
+	for i := range args {
+		if args[i] != redacted[i] {
+			return strings.Join(redacted, " ")
+		}
+	}
+	return s
+}
+
+// redactOptions redacts a comma-separated list of key=value options like "-o" of mount
+func redactOptions(s string) string {
+	if !strings.Contains(s, "=") {
+		return s
+	}
+	opts := strings.Split(s, ",")
+	for i, opt := range opts {
+		opts[i] = redactKeyValue(opt)
+	}
+	return strings.Join(opts, ",")
+}
+
+func redactKeyValue(kv string) string {
+	if eq := strings.IndexByte(kv, '='); eq >= 0 && IsSensitive(kv[:eq]) {
+		return kv[:eq+1] + Redacted
+	}
+	return kv
+}
+
+// redactValue redacts a field value by its key and its contents
+func redactValue(key string, value interface{}) interface{} {
+	if IsSensitive(key) {
+		return Redacted
+	}
+	switch v := value.(type) {
+	case map[string]string:
+		return RedactMap(v)
+	case []string:
+		return RedactArgs(v)
+	case string:
+		if strings.Contains(v, "=") || strings.HasPrefix(v, "-") {
+			return redactString(v)
+		}
+	}
+	return value
+}
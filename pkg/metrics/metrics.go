@@ -0,0 +1,131 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/logging"
+)
+
+const namespace = "csi_s3"
+
+var (
+	rpcRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rpc_requests_total",
+		Help:      "Number of CSI RPC calls by method and gRPC status code.",
+	}, []string{"method", "code"})
+	rpcDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "rpc_duration_seconds",
+		Help:      "Duration of CSI RPC calls by method.",
+		Buckets:   []float64{0.01, 0.05, 0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120},
+	}, []string{"method"})
+
+	s3Requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "s3_requests_total",
+		Help:      "Number of S3 API calls by operation and result.",
+	}, []string{"operation", "result"})
+	s3Duration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "s3_request_duration_seconds",
+		Help:      "Duration of S3 API calls by operation.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+	deletedBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "s3_deleted_bytes_total",
+		Help:      "Size of S3 objects deleted with volumes.",
+	})
+
+	mountOperations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mount_operations_total",
+		Help:      "Number of mount and unmount operations by mounter and result.",
+	}, []string{"mounter", "operation", "result"})
+	mountDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "mount_duration_seconds",
+		Help:      "Duration of mount and unmount operations by mounter.",
+		Buckets:   []float64{0.1, 0.5, 1, 2.5, 5, 10, 20, 30, 60},
+	}, []string{"mounter", "operation"})
+	activeMounts = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_mounts",
+		Help:      "Number of FUSE mounts served by the node.",
+	})
+	fuseRestarts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "fuse_restarts_total",
+		Help:      "Number of restarts of crashed FUSE processes by command.",
+	}, []string{"command"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		rpcRequests, rpcDuration,
+		s3Requests, s3Duration, deletedBytes,
+		mountOperations, mountDuration, activeMounts, fuseRestarts,
+	)
+}
+
+// Serve serves metrics at http://address/metrics, it blocks until the
+// listener fails
+func Serve(address string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	logging.Infof("Serving metrics on %s", address)
+	if err := http.ListenAndServe(address, mux); err != nil {
+		logging.Errorf("Error serving metrics on %s: %v", address, err)
+	}
+}
+
+// UnaryInterceptor records counts, latencies and status codes of RPC calls
+func UnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	rpcDuration.WithLabelValues(info.FullMethod).Observe(time.Since(start).Seconds())
+	rpcRequests.WithLabelValues(info.FullMethod, status.Code(err).String()).Inc()
+	return resp, err
+}
+
+// ObserveS3 records an S3 API call which started at start
+func ObserveS3(operation string, start time.Time, err error) {
+	s3Duration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	s3Requests.WithLabelValues(operation, result(err)).Inc()
+}
+
+// AddDeletedBytes records the size of deleted S3 objects
+func AddDeletedBytes(n int64) {
+	deletedBytes.Add(float64(n))
+}
+
+// ObserveMount records a mount or unmount operation which started at start
+func ObserveMount(mounter, operation string, start time.Time, err error) {
+	mountDuration.WithLabelValues(mounter, operation).Observe(time.Since(start).Seconds())
+	mountOperations.WithLabelValues(mounter, operation, result(err)).Inc()
+}
+
+// SetActiveMounts sets the number of FUSE mounts served by the node
+func SetActiveMounts(n int) {
+	activeMounts.Set(float64(n))
+}
+
+// IncFuseRestarts records a restart of a crashed FUSE process
+func IncFuseRestarts(command string) {
+	fuseRestarts.WithLabelValues(command).Inc()
+}
+
+func result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestMetrics(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Metrics")
+}
+
+var _ = Describe("Metrics", func() {
+	It("counts RPC calls by method and status code", func() {
+		const method = "/csi.v1.Node/NodeStageVolume"
+		info := &grpc.UnaryServerInfo{FullMethod: method}
+		failed := testutil.ToFloat64(rpcRequests.WithLabelValues(method, "NotFound"))
+		succeeded := testutil.ToFloat64(rpcRequests.WithLabelValues(method, "OK"))
+
+		_, err := UnaryInterceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, status.Error(codes.NotFound, "no volume")
+		})
+		Expect(status.Code(err)).To(Equal(codes.NotFound))
+		resp, err := UnaryInterceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return "response", nil
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp).To(Equal("response"))
+
+		Expect(testutil.ToFloat64(rpcRequests.WithLabelValues(method, "NotFound"))).To(Equal(failed + 1))
+		Expect(testutil.ToFloat64(rpcRequests.WithLabelValues(method, "OK"))).To(Equal(succeeded + 1))
+	})
+
+	It("counts S3 calls by result", func() {
+		ok := testutil.ToFloat64(s3Requests.WithLabelValues("PutObject", "success"))
+		failed := testutil.ToFloat64(s3Requests.WithLabelValues("PutObject", "error"))
+		ObserveS3("PutObject", time.Now(), nil)
+		ObserveS3("PutObject", time.Now(), errors.New("denied"))
+		ObserveS3("PutObject", time.Now(), errors.New("denied"))
+		Expect(testutil.ToFloat64(s3Requests.WithLabelValues("PutObject", "success"))).To(Equal(ok + 1))
+		Expect(testutil.ToFloat64(s3Requests.WithLabelValues("PutObject", "error"))).To(Equal(failed + 2))
+	})
+
+	It("counts mounts, deleted bytes and FUSE restarts", func() {
+		mounts := testutil.ToFloat64(mountOperations.WithLabelValues("geesefs", "mount", "error"))
+		deleted := testutil.ToFloat64(deletedBytes)
+		restarts := testutil.ToFloat64(fuseRestarts.WithLabelValues("geesefs"))
+
+		ObserveMount("geesefs", "mount", time.Now(), errors.New("timeout"))
+		AddDeletedBytes(1024)
+		IncFuseRestarts("geesefs")
+		SetActiveMounts(3)
+
+		Expect(testutil.ToFloat64(mountOperations.WithLabelValues("geesefs", "mount", "error"))).To(Equal(mounts + 1))
+		Expect(testutil.ToFloat64(deletedBytes)).To(Equal(deleted + 1024))
+		Expect(testutil.ToFloat64(fuseRestarts.WithLabelValues("geesefs"))).To(Equal(restarts + 1))
+		Expect(testutil.ToFloat64(activeMounts)).To(Equal(3.0))
+	})
+
+	It("serves metrics over HTTP", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		address := listener.Addr().String()
+		Expect(listener.Close()).To(Succeed())
+		go Serve(address)
+
+		var body []byte
+		Eventually(func() error {
+			resp, err := http.Get("http://" + address + "/metrics")
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+			body, err = ioutil.ReadAll(resp.Body)
+			return err
+		}, 5*time.Second).Should(Succeed())
+		Expect(string(body)).To(ContainSubstring("csi_s3_active_mounts"))
+		Expect(string(body)).To(ContainSubstring("csi_s3_rpc_requests_total"))
+	})
+})
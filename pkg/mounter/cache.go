@@ -0,0 +1,156 @@
+package mounter
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/logging"
+)
+
+const (
+	cacheDataDir      = "data"
+	cacheLimitFile    = "limit"
+	cacheTrimInterval = time.Minute
+	// Recently modified files may still be in use by the mounter
+	cacheTrimGrace = time.Minute
+)
+
+// Cache manages per-volume disk cache directories under a node-wide root
+// directory. Mounters only get the path of their own volume's directory,
+// and Run keeps the size of each directory under the volume's limit.
+type Cache struct {
+	root string
+}
+
+func NewCache(root string) *Cache {
+	return &Cache{root: root}
+}
+
+func (c *Cache) volumeDir(volumeID string) string {
+	// Volume IDs may contain slashes and other unsafe characters
+	h := sha1.Sum([]byte(volumeID))
+	return filepath.Join(c.root, hex.EncodeToString(h[:]))
+}
+
+// Prepare creates the cache directory for a volume and returns its path
+func (c *Cache) Prepare(volumeID string, limit int64) (string, error) {
+	dir := c.volumeDir(volumeID)
+	// Mounters may drop root privileges, so parent directories must be traversable
+	if err := os.MkdirAll(dir, 0711); err != nil {
+		return "", fmt.Errorf("Error creating cache directory for volume %s: %v", volumeID, err)
+	}
+	data := filepath.Join(dir, cacheDataDir)
+	if err := os.Mkdir(data, 0700); err != nil && !os.IsExist(err) {
+		return "", fmt.Errorf("Error creating cache directory for volume %s: %v", volumeID, err)
+	}
+	err := ioutil.WriteFile(filepath.Join(dir, cacheLimitFile), []byte(strconv.FormatInt(limit, 10)), 0600)
+	if err != nil {
+		return "", fmt.Errorf("Error writing cache limit for volume %s: %v", volumeID, err)
+	}
+	return data, nil
+}
+
+// Remove deletes the cache directory of a volume
+func (c *Cache) Remove(volumeID string) error {
+	return os.RemoveAll(c.volumeDir(volumeID))
+}
+
+// Run periodically evicts least recently accessed files from
+// volume cache directories which exceed their limits
+func (c *Cache) Run() {
+	for {
+		c.trim()
+		time.Sleep(cacheTrimInterval)
+	}
+}
+
+func (c *Cache) trim() {
+	dirs, err := ioutil.ReadDir(c.root)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logging.Errorf("Error listing cache directory %s: %v", c.root, err)
+		}
+		return
+	}
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		dir := filepath.Join(c.root, d.Name())
+		buf, err := ioutil.ReadFile(filepath.Join(dir, cacheLimitFile))
+		if err != nil {
+			continue
+		}
+		limit, err := strconv.ParseInt(strings.TrimSpace(string(buf)), 10, 64)
+		if err != nil || limit <= 0 {
+			continue
+		}
+		if err := trimCacheDir(filepath.Join(dir, cacheDataDir), limit); err != nil {
+			logging.Errorf("Error trimming cache directory %s: %v", dir, err)
+		}
+	}
+}
+
+type cacheFile struct {
+	path  string
+	size  int64
+	atime time.Time
+	mtime time.Time
+}
+
+func trimCacheDir(dir string, limit int64) error {
+	var files []cacheFile
+	var total int64
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f := cacheFile{path: path, size: info.Size(), mtime: info.ModTime(), atime: info.ModTime()}
+		if st, ok := info.Sys().(*syscall.Stat_t); ok {
+			// Cache files are usually sparse
+			f.size = st.Blocks * 512
+			f.atime = time.Unix(st.Atim.Sec, st.Atim.Nsec)
+		}
+		total += f.size
+		files = append(files, f)
+		return nil
+	})
+	if err != nil || total <= limit {
+		return err
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].atime.Before(files[j].atime)
+	})
+	removed := 0
+	for _, f := range files {
+		if total <= limit {
+			break
+		}
+		if time.Since(f.mtime) < cacheTrimGrace {
+			continue
+		}
+		if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+			logging.Warningf("Error removing cache file %s: %v", f.path, err)
+			continue
+		}
+		total -= f.size
+		removed++
+	}
+	logging.V(4).Infof("Removed %v files from cache directory %s, %v bytes left", removed, dir, total)
+	return nil
+}
@@ -0,0 +1,90 @@
+package mounter
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Cache", func() {
+	var root string
+	var cache *Cache
+
+	BeforeEach(func() {
+		var err error
+		root, err = ioutil.TempDir("", "cache")
+		Expect(err).NotTo(HaveOccurred())
+		cache = NewCache(root)
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(root)).To(Succeed())
+	})
+
+	// writeFile writes a cache file with the given access and modification times
+	writeFile := func(dir, name string, atime, mtime time.Time) string {
+		path := filepath.Join(dir, name)
+		Expect(ioutil.WriteFile(path, bytes.Repeat([]byte{1}, 8192), 0600)).To(Succeed())
+		Expect(os.Chtimes(path, atime, mtime)).To(Succeed())
+		return path
+	}
+
+	It("prepares a separate directory for each volume", func() {
+		dir1, err := cache.Prepare("bucket/prefix", 1<<20)
+		Expect(err).NotTo(HaveOccurred())
+		dir2, err := cache.Prepare("bucket/other", 1<<20)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(dir1).NotTo(Equal(dir2))
+		Expect(filepath.Dir(filepath.Dir(dir1))).To(Equal(root))
+		Expect(dir1).To(BeADirectory())
+
+		limit, err := ioutil.ReadFile(filepath.Join(filepath.Dir(dir1), cacheLimitFile))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(limit)).To(Equal("1048576"))
+
+		again, err := cache.Prepare("bucket/prefix", 2<<20)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(again).To(Equal(dir1))
+	})
+
+	It("removes the directory of a volume", func() {
+		dir, err := cache.Prepare("bucket", 1<<20)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cache.Remove("bucket")).To(Succeed())
+		Expect(filepath.Dir(dir)).NotTo(BeAnExistingFile())
+		Expect(cache.Remove("bucket")).To(Succeed())
+	})
+
+	It("evicts least recently accessed files over the limit", func() {
+		dir, err := cache.Prepare("bucket", 12<<10)
+		Expect(err).NotTo(HaveOccurred())
+		old := time.Now().Add(-time.Hour)
+		oldest := writeFile(dir, "oldest", old.Add(-time.Minute), old)
+		newer := writeFile(dir, "newer", old, old)
+		cache.trim()
+		Expect(oldest).NotTo(BeAnExistingFile())
+		Expect(newer).To(BeAnExistingFile())
+	})
+
+	It("keeps recently modified files", func() {
+		dir, err := cache.Prepare("bucket", 1)
+		Expect(err).NotTo(HaveOccurred())
+		path := writeFile(dir, "file", time.Now().Add(-time.Hour), time.Now())
+		cache.trim()
+		Expect(path).To(BeAnExistingFile())
+	})
+
+	It("keeps files of volumes without a limit", func() {
+		dir, err := cache.Prepare("bucket", 0)
+		Expect(err).NotTo(HaveOccurred())
+		old := time.Now().Add(-time.Hour)
+		path := writeFile(dir, "file", old, old)
+		cache.trim()
+		Expect(path).To(BeAnExistingFile())
+	})
+})
@@ -0,0 +1,185 @@
+package mounter
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/logging"
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/s3"
+)
+
+const (
+	// Argument replaced with allowed options of the volume
+	execOptionsArg = "{options}"
+)
+
+// ExecMounterConfig describes an external FUSE filesystem started by the
+// generic exec mounter
+type ExecMounterConfig struct {
+	// Mounter name used in the "mounter" volume parameter
+	Name string `yaml:"name"`
+	// Absolute path of the binary, it must stay in foreground
+	Binary string `yaml:"binary"`
+	// Arguments with {bucket}, {prefix}, {target}, {endpoint}, {region} and
+	// {volumeID} placeholders. An argument equal to {options} is replaced
+	// with allowed options from the "options" volume parameter.
+	Args []string `yaml:"args"`
+	// Environment variables like KEY=VALUE with the same placeholders
+	// as Args and also {accessKeyID} and {secretAccessKey}
+	Env []string `yaml:"env"`
+	// Options which may be set in the "options" volume parameter, like
+	// "--read-only". Values must be passed as "--option=value".
+	AllowedOptions []string `yaml:"allowedOptions"`
+}
+
+type execMountersConfig struct {
+	Mounters []ExecMounterConfig `yaml:"mounters"`
+}
+
+// Implements Mounter
+type execMounter struct {
+	config *ExecMounterConfig
+	meta   *s3.FSMeta
+	cfg    *s3.Config
+}
+
+// LoadExecMounters registers exec mounters described in a YAML config file
+func LoadExecMounters(path string) error {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Error reading mounter config %s: %v", path, err)
+	}
+	var config execMountersConfig
+	if err := yaml.UnmarshalStrict(buf, &config); err != nil {
+		return fmt.Errorf("Error parsing mounter config %s: %v", path, err)
+	}
+	for i := range config.Mounters {
+		mc := &config.Mounters[i]
+		if err := mc.validate(); err != nil {
+			return fmt.Errorf("Invalid mounter %q in %s: %v", mc.Name, path, err)
+		}
+		factory := func(meta *s3.FSMeta, cfg *s3.Config) (Mounter, error) {
+			return &execMounter{config: mc, meta: meta, cfg: cfg}, nil
+		}
+		if err := register(mc.Name, factory, nil); err != nil {
+			return err
+		}
+		requireBinary(mc.Binary)
+		logging.Infof("Registered exec mounter %s using %s", mc.Name, mc.Binary)
+	}
+	return nil
+}
+
+func (mc *ExecMounterConfig) validate() error {
+	if mc.Name == "" {
+		return fmt.Errorf("name is empty")
+	}
+	if !filepath.IsAbs(mc.Binary) {
+		return fmt.Errorf("binary must be an absolute path")
+	}
+	hasTarget := false
+	for _, arg := range mc.Args {
+		// Command lines of processes are visible to everyone
+		if strings.Contains(arg, "{accessKeyID}") || strings.Contains(arg, "{secretAccessKey}") {
+			return fmt.Errorf("credentials may only be passed in env")
+		}
+		if strings.Contains(arg, "{target}") {
+			hasTarget = true
+		}
+	}
+	if !hasTarget {
+		// The target is also used to find the process on unmount
+		return fmt.Errorf("args must contain {target}")
+	}
+	for _, env := range mc.Env {
+		if strings.Index(env, "=") <= 0 {
+			return fmt.Errorf("env %q must be KEY=VALUE", env)
+		}
+	}
+	for _, opt := range mc.AllowedOptions {
+		if !strings.HasPrefix(opt, "-") || strings.Contains(opt, "=") {
+			return fmt.Errorf("allowed option %q must be like --option", opt)
+		}
+	}
+	return nil
+}
+
+func (m *execMounter) Mount(ctx context.Context, target, volumeID string) error {
+	replacements := []string{
+		"{bucket}", m.meta.BucketName,
+		"{prefix}", m.meta.Prefix,
+		"{target}", target,
+		"{endpoint}", m.cfg.Endpoint,
+		"{region}", m.cfg.Region,
+		"{volumeID}", volumeID,
+	}
+	argReplacer := strings.NewReplacer(replacements...)
+	envReplacer := strings.NewReplacer(append(
+		replacements,
+		"{accessKeyID}", m.cfg.AccessKeyID,
+		"{secretAccessKey}", m.cfg.SecretAccessKey,
+	)...)
+	log := logging.FromContext(ctx)
+	opts := m.allowedOptions(log)
+	var args []string
+	hasOptions := false
+	for _, arg := range m.config.Args {
+		if arg == execOptionsArg {
+			args = append(args, opts...)
+			hasOptions = true
+		} else {
+			args = append(args, argReplacer.Replace(arg))
+		}
+	}
+	if !hasOptions && len(opts) > 0 {
+		log.Warningf("Mounter %s doesn't accept options, ignoring %v", m.config.Name, opts)
+	}
+	envs := make([]string, 0, len(m.config.Env))
+	for _, env := range m.config.Env {
+		envs = append(envs, envReplacer.Replace(env))
+	}
+	return fuseMount(ctx, target, volumeID, m.config.Binary, args, envs, mountTimeout(m.meta))
+}
+
+func (m *execMounter) Unmount(ctx context.Context, target, volumeID string) error {
+	return FuseUnmount(ctx, target, unmountTimeout(m.meta))
+}
+
+func (m *execMounter) Status(target, volumeID string) error {
+	return checkFuseMount(target)
+}
+
+// Capabilities of external filesystems are unknown, so they can't be
+// mounted read-only or with the disk cache
+func (m *execMounter) Capabilities() Capabilities {
+	return Capabilities{}
+}
+
+// allowedOptions filters options of the volume through the allowlist
+func (m *execMounter) allowedOptions(log logging.Logger) []string {
+	var res []string
+	for _, opt := range m.meta.MountOptions {
+		name := opt
+		if e := strings.Index(opt, "="); e >= 0 {
+			name = opt[0:e]
+		}
+		allowed := false
+		for _, a := range m.config.AllowedOptions {
+			if a == name {
+				allowed = true
+				break
+			}
+		}
+		if allowed {
+			res = append(res, opt)
+		} else {
+			log.Warningf("Option %q is not allowed for mounter %s, ignoring it", opt, m.config.Name)
+		}
+	}
+	return res
+}
@@ -0,0 +1,160 @@
+package mounter
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"k8s.io/kubernetes/pkg/util/mount"
+
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/s3"
+)
+
+var _ = Describe("Exec mounters", func() {
+	var dir, configFile string
+	var binaries []string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "exec-mounter")
+		Expect(err).NotTo(HaveOccurred())
+		configFile = filepath.Join(dir, "mounters.yaml")
+		binariesMu.Lock()
+		binaries = requiredBinaries
+		binariesMu.Unlock()
+	})
+
+	AfterEach(func() {
+		unregister("test-exec")
+		binariesMu.Lock()
+		requiredBinaries = binaries
+		binariesMu.Unlock()
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	load := func(config string) error {
+		Expect(ioutil.WriteFile(configFile, []byte(config), 0644)).To(Succeed())
+		return LoadExecMounters(configFile)
+	}
+
+	table.DescribeTable("rejects invalid configs",
+		func(config, message string) {
+			err := load(config)
+			Expect(err).To(MatchError(ContainSubstring(message)))
+			_, registered := lookup("test-exec")
+			Expect(registered).To(BeFalse())
+		},
+		table.Entry("unknown fields", `
+mounters:
+  - name: test-exec
+    binary: /bin/myfs
+    args: ["{target}"]
+    arguments: []
+`, "field arguments not found"),
+		table.Entry("relative binary", `
+mounters:
+  - name: test-exec
+    binary: myfs
+    args: ["{target}"]
+`, "binary must be an absolute path"),
+		table.Entry("no target", `
+mounters:
+  - name: test-exec
+    binary: /bin/myfs
+    args: ["{bucket}"]
+`, "args must contain {target}"),
+		table.Entry("credentials in args", `
+mounters:
+  - name: test-exec
+    binary: /bin/myfs
+    args: ["--key={secretAccessKey}", "{target}"]
+`, "credentials may only be passed in env"),
+		table.Entry("invalid env", `
+mounters:
+  - name: test-exec
+    binary: /bin/myfs
+    args: ["{target}"]
+    env: ["KEY"]
+`, `env "KEY" must be KEY=VALUE`),
+		table.Entry("allowed option with a value", `
+mounters:
+  - name: test-exec
+    binary: /bin/myfs
+    args: ["{target}"]
+    allowedOptions: ["--ttl=1m"]
+`, `allowed option "--ttl=1m" must be like --option`),
+		table.Entry("built-in names", `
+mounters:
+  - name: geesefs
+    binary: /bin/myfs
+    args: ["{target}"]
+`, `mounter "geesefs" is already registered`),
+	)
+
+	It("registers mounters without typed parameters", func() {
+		Expect(load(`
+mounters:
+  - name: test-exec
+    binary: /bin/myfs
+    args: ["{target}"]
+`)).To(Succeed())
+		_, registered := lookup("test-exec")
+		Expect(registered).To(BeTrue())
+		Expect(requiredBinaries).To(ContainElement("/bin/myfs"))
+		err := ParseParams(&s3.FSMeta{Mounter: "test-exec"}, nil, map[string]string{UidKey: "1000"})
+		Expect(err).To(MatchError("mounter test-exec does not support parameters: uid"))
+	})
+
+	It("replaces placeholders and filters options", func() {
+		fake, restore := installFakeMounter(dir, "myfs")
+		defer restore()
+		target := filepath.Join(dir, "target")
+		Expect(os.Mkdir(target, 0755)).To(Succeed())
+		defer mount.New("").Unmount(target)
+		Expect(load(`
+mounters:
+  - name: test-exec
+    binary: ` + fake.path + `
+    args:
+      - "--endpoint={endpoint}"
+      - "--region={region}"
+      - "{options}"
+      - "{bucket}:{prefix}"
+      - "--id={volumeID}"
+      - "{target}"
+    env:
+      - "AWS_ACCESS_KEY_ID={accessKeyID}"
+      - "AWS_SECRET_ACCESS_KEY={secretAccessKey}"
+    allowedOptions:
+      - "--read-only"
+      - "--ttl"
+`)).To(Succeed())
+
+		meta := &s3.FSMeta{
+			BucketName:   "bucket",
+			Prefix:       "prefix",
+			Mounter:      "test-exec",
+			MountOptions: []string{"--read-only", "--ttl=1m", "--config=/etc/passwd"},
+		}
+		m, err := New(meta, &s3.Config{Endpoint: "http://s3.local", Region: "us-east-1", AccessKeyID: "id", SecretAccessKey: "secret"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(m.Capabilities()).To(Equal(Capabilities{}))
+		Expect(m.Mount(context.Background(), target, "bucket/prefix")).To(Succeed())
+		Expect(fake.args()).To(Equal([]string{
+			"--endpoint=http://s3.local",
+			"--region=us-east-1",
+			"--read-only", "--ttl=1m",
+			"bucket:prefix",
+			"--id=bucket/prefix",
+			target,
+		}))
+		Expect(fake.env()).To(ContainElements("AWS_ACCESS_KEY_ID=id", "AWS_SECRET_ACCESS_KEY=secret"))
+
+		Expect(m.Unmount(context.Background(), target, "bucket/prefix")).To(Succeed())
+		Expect(m.Status(target, "bucket/prefix")).NotTo(Succeed())
+	})
+})
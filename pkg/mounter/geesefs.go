@@ -1,20 +1,19 @@
 package mounter
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"strings"
-	"time"
 
 	systemd "github.com/coreos/go-systemd/v22/dbus"
-	dbus "github.com/godbus/dbus/v5"
-	"github.com/golang/glog"
 
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/logging"
 	"github.com/yandex-cloud/k8s-csi-s3/pkg/s3"
 )
 
 const (
-	geesefsCmd    = "geesefs"
+	geesefsCmd = "geesefs"
 )
 
 // Implements Mounter
@@ -26,6 +25,10 @@ type geesefsMounter struct {
 	secretAccessKey string
 }
 
+func init() {
+	Register(geesefsMounterType, newGeeseFSMounter, MemoryLimitKey, ReadAheadKey, DirModeKey, FileModeKey, UidKey, GidKey, CacheSizeKey)
+}
+
 func newGeeseFSMounter(meta *s3.FSMeta, cfg *s3.Config) (Mounter, error) {
 	return &geesefsMounter{
 		meta:            meta,
@@ -36,41 +39,9 @@ func newGeeseFSMounter(meta *s3.FSMeta, cfg *s3.Config) (Mounter, error) {
 	}, nil
 }
 
-func (geesefs *geesefsMounter) CopyBinary(from, to string) error {
-	st, err := os.Stat(from)
-	if err != nil {
-		return fmt.Errorf("Failed to stat %s: %v", from, err)
-	}
-	st2, err := os.Stat(to)
-	if err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("Failed to stat %s: %v", to, err)
-	}
-	if err != nil || st2.Size() != st.Size() || st2.ModTime() != st.ModTime() {
-		if err == nil {
-			// remove the file first to not hit "text file busy" errors
-			err = os.Remove(to)
-			if err != nil {
-				return fmt.Errorf("Error removing %s to update it: %v", to, err)
-			}
-		}
-		bin, err := os.ReadFile(from)
-		if err != nil {
-			return fmt.Errorf("Error copying %s to %s: %v", from, to, err)
-		}
-		err = os.WriteFile(to, bin, 0755)
-		if err != nil {
-			return fmt.Errorf("Error copying %s to %s: %v", from, to, err)
-		}
-		err = os.Chtimes(to, st.ModTime(), st.ModTime())
-		if err != nil {
-			return fmt.Errorf("Error copying %s to %s: %v", from, to, err)
-		}
-	}
-	return nil
-}
-
-func (geesefs *geesefsMounter) MountDirect(target string, args []string) error {
+func (geesefs *geesefsMounter) MountDirect(ctx context.Context, target, volumeID string, args []string) error {
 	args = append([]string{
+		"-f",
 		"--endpoint", geesefs.endpoint,
 		"-o", "allow_other",
 		"--log-file", "/dev/stderr",
@@ -79,16 +50,37 @@ func (geesefs *geesefsMounter) MountDirect(target string, args []string) error {
 		"AWS_ACCESS_KEY_ID=" + geesefs.accessKeyID,
 		"AWS_SECRET_ACCESS_KEY=" + geesefs.secretAccessKey,
 	}
-	return fuseMount(target, geesefsCmd, args, envs)
+	return fuseMount(ctx, target, volumeID, binaryPath(geesefsCmd), args, envs, mountTimeout(geesefs.meta))
 }
 
-type execCmd struct {
-	Path             string
-	Args             []string
-	UncleanIsFailure bool
+func (geesefs *geesefsMounter) typedArgs() []string {
+	var args []string
+	meta := geesefs.meta
+	if meta.MemoryLimit > 0 {
+		args = append(args, "--memory-limit", fmt.Sprintf("%d", ceilDiv(meta.MemoryLimit, 1<<20)))
+	}
+	if meta.ReadAhead > 0 {
+		args = append(args, "--read-ahead", fmt.Sprintf("%d", ceilDiv(meta.ReadAhead, 1<<10)))
+	}
+	if meta.DirMode != 0 {
+		args = append(args, "--dir-mode", fmt.Sprintf("%#o", uint32(meta.DirMode)))
+	}
+	if meta.FileMode != 0 {
+		args = append(args, "--file-mode", fmt.Sprintf("%#o", uint32(meta.FileMode)))
+	}
+	if meta.Uid != nil {
+		args = append(args, "--uid", fmt.Sprintf("%d", *meta.Uid))
+	}
+	if meta.Gid != nil {
+		args = append(args, "--gid", fmt.Sprintf("%d", *meta.Gid))
+	}
+	if meta.ReadOnly {
+		args = append(args, "-o", "ro")
+	}
+	return args
 }
 
-func (geesefs *geesefsMounter) Mount(target, volumeID string) error {
+func (geesefs *geesefsMounter) Mount(ctx context.Context, target, volumeID string) error {
 	fullPath := fmt.Sprintf("%s:%s", geesefs.meta.BucketName, geesefs.meta.Prefix)
 	var args []string
 	if geesefs.region != "" {
@@ -99,6 +91,14 @@ func (geesefs *geesefsMounter) Mount(target, volumeID string) error {
 		"--setuid", "65534", // nobody. drop root privileges
 		"--setgid", "65534", // nogroup
 	)
+	args = append(args, geesefs.typedArgs()...)
+	if geesefs.meta.CacheDir != "" {
+		// geesefs drops privileges, so the cache must be writable by nobody
+		if err := os.Chown(geesefs.meta.CacheDir, 65534, 65534); err != nil {
+			return fmt.Errorf("Error changing owner of cache directory %s: %v", geesefs.meta.CacheDir, err)
+		}
+		args = append(args, "--cache", geesefs.meta.CacheDir)
+	}
 	useSystemd := true
 	for i := 0; i < len(geesefs.meta.MountOptions); i++ {
 		opt := geesefs.meta.MountOptions[i]
@@ -116,7 +116,7 @@ func (geesefs *geesefsMounter) Mount(target, volumeID string) error {
 				key = opt[s:e]
 			}
 			if key == "log-file" || key == "shared-config" || key == "cache" {
-				// Skip options accessing local FS
+				// Skip options accessing local FS, the cache directory is managed by the driver
 				if e < 0 {
 					i++
 				}
@@ -130,76 +130,36 @@ func (geesefs *geesefsMounter) Mount(target, volumeID string) error {
 	args = append(args, fullPath, target)
 	// Try to start geesefs using systemd so it doesn't get killed when the container exits
 	if !useSystemd {
-		return geesefs.MountDirect(target, args)
+		return geesefs.MountDirect(ctx, target, volumeID, args)
 	}
 	conn, err := systemd.New()
 	if err != nil {
-		glog.Errorf("Failed to connect to systemd dbus service: %v, starting geesefs directly", err)
-		return geesefs.MountDirect(target, args)
+		logging.FromContext(ctx).Errorf("Failed to connect to systemd dbus service: %v, starting geesefs directly", err)
+		return geesefs.MountDirect(ctx, target, volumeID, args)
 	}
 	defer conn.Close()
-	// systemd is present
-	if err = geesefs.CopyBinary("/usr/bin/geesefs", "/csi/geesefs"); err != nil {
-		return err
-	}
-	pluginDir := os.Getenv("PLUGIN_DIR")
-	if pluginDir == "" {
-		pluginDir = "/var/lib/kubelet/plugins/ru.yandex.s3.csi"
-	}
-	args = append([]string{pluginDir+"/geesefs", "-f", "-o", "allow_other", "--endpoint", geesefs.endpoint}, args...)
-	glog.Info("Starting geesefs using systemd: "+strings.Join(args, " "))
-	unitName := "geesefs-"+systemd.PathBusEscape(volumeID)+".service"
-	newProps := []systemd.Property{
-		systemd.Property{
-			Name: "Description",
-			Value: dbus.MakeVariant("GeeseFS mount for Kubernetes volume "+volumeID),
-		},
-		systemd.PropExecStart(args, false),
-		systemd.Property{
-			Name: "ExecStopPost",
-			// force & lazy unmount to cleanup possibly dead mountpoints
-			Value: dbus.MakeVariant([]execCmd{ execCmd{ "/bin/umount", []string{ "/bin/umount", "-f", "-l", target }, false } }),
-		},
-		systemd.Property{
-			Name: "Environment",
-			Value: dbus.MakeVariant([]string{ "AWS_ACCESS_KEY_ID="+geesefs.accessKeyID, "AWS_SECRET_ACCESS_KEY="+geesefs.secretAccessKey }),
-		},
-		systemd.Property{
-			Name: "CollectMode",
-			Value: dbus.MakeVariant("inactive-or-failed"),
-		},
-	}
-	unitProps, err := conn.GetAllProperties(unitName)
-	if err == nil {
-		// Unit already exists
-		if s, ok := unitProps["ActiveState"].(string); ok && (s == "active" || s == "activating" || s == "reloading") {
-			// Unit is already active
-			curPath := ""
-			prevExec, ok := unitProps["ExecStart"].([][]interface{})
-			if ok && len(prevExec) > 0 && len(prevExec[0]) >= 2 {
-				execArgs, ok := prevExec[0][1].([]string)
-				if ok && len(execArgs) >= 2 {
-					curPath = execArgs[len(execArgs)-1]
-				}
-			}
-			if curPath != target {
-				return fmt.Errorf(
-					"GeeseFS for volume %v is already mounted on host, but"+
-					" in a different directory. We want %v, but it's in %v",
-					volumeID, target, curPath,
-				)
-			}
-			// Already mounted at right location
-			return nil
-		} else {
-			// Stop and garbage collect the unit if automatic collection didn't work for some reason
-			conn.StopUnit(unitName, "replace", nil)
-			conn.ResetFailedUnit(unitName)
-		}
+	args = append([]string{"-f", "-o", "allow_other", "--endpoint", geesefs.endpoint}, args...)
+	envs := []string{
+		"AWS_ACCESS_KEY_ID=" + geesefs.accessKeyID,
+		"AWS_SECRET_ACCESS_KEY=" + geesefs.secretAccessKey,
 	}
-	_, err = conn.StartTransientUnit(unitName, "replace", newProps, nil)
-	if err != nil {
-		return fmt.Errorf("Error starting systemd unit %s on host: %v", unitName, err)
+	return systemdMount(ctx, conn, geesefsCmd, "GeeseFS", volumeID, target, args, envs, geesefs.meta)
+}
+
+func (geesefs *geesefsMounter) Unmount(ctx context.Context, target, volumeID string) error {
+	return systemdOrFuseUnmount(ctx, geesefsCmd, target, volumeID, unmountTimeout(geesefs.meta))
+}
+
+func (geesefs *geesefsMounter) Status(target, volumeID string) error {
+	return checkFuseMount(target)
+}
+
+func (geesefs *geesefsMounter) Capabilities() Capabilities {
+	return Capabilities{
+		Renames:      true,
+		Symlinks:     true,
+		RandomWrites: true,
+		ReadOnly:     true,
+		Cache:        true,
 	}
-	return waitForMount(target, 10*time.Second)
 }
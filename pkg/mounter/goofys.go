@@ -0,0 +1,114 @@
+package mounter
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/s3"
+)
+
+const (
+	goofysCmd = "goofys"
+)
+
+// Implements Mounter
+type goofysMounter struct {
+	meta            *s3.FSMeta
+	endpoint        string
+	region          string
+	accessKeyID     string
+	secretAccessKey string
+}
+
+func init() {
+	Register(goofysMounterType, newGoofysMounter, DirModeKey, FileModeKey, UidKey, GidKey)
+}
+
+func newGoofysMounter(meta *s3.FSMeta, cfg *s3.Config) (Mounter, error) {
+	return &goofysMounter{
+		meta:            meta,
+		endpoint:        cfg.Endpoint,
+		region:          cfg.Region,
+		accessKeyID:     cfg.AccessKeyID,
+		secretAccessKey: cfg.SecretAccessKey,
+	}, nil
+}
+
+func (goofys *goofysMounter) Mount(ctx context.Context, target, volumeID string) error {
+	args := []string{
+		"-f",
+		"--endpoint", goofys.endpoint,
+		"-o", "allow_other",
+	}
+	if goofys.region != "" {
+		args = append(args, "--region", goofys.region)
+	}
+	if goofys.meta.DirMode != 0 {
+		args = append(args, "--dir-mode", fmt.Sprintf("%#o", uint32(goofys.meta.DirMode)))
+	}
+	if goofys.meta.FileMode != 0 {
+		args = append(args, "--file-mode", fmt.Sprintf("%#o", uint32(goofys.meta.FileMode)))
+	}
+	if goofys.meta.Uid != nil {
+		args = append(args, "--uid", fmt.Sprintf("%d", *goofys.meta.Uid))
+	}
+	if goofys.meta.Gid != nil {
+		args = append(args, "--gid", fmt.Sprintf("%d", *goofys.meta.Gid))
+	}
+	if goofys.meta.ReadOnly {
+		args = append(args, "-o", "ro")
+	}
+	args = append(args, goofysSafeOptions(goofys.meta.MountOptions)...)
+	fullPath := goofys.meta.BucketName
+	if goofys.meta.Prefix != "" {
+		fullPath = fmt.Sprintf("%s:%s", goofys.meta.BucketName, goofys.meta.Prefix)
+	}
+	args = append(args, fullPath, target)
+	envs := []string{
+		"AWS_ACCESS_KEY_ID=" + goofys.accessKeyID,
+		"AWS_SECRET_ACCESS_KEY=" + goofys.secretAccessKey,
+	}
+	return fuseMount(ctx, target, volumeID, goofysCmd, args, envs, mountTimeout(goofys.meta))
+}
+
+// goofysSafeOptions removes options accessing the local FS. goofys disk
+// cache requires catfs and isn't supported, so --cache is removed too.
+func goofysSafeOptions(opts []string) []string {
+	var res []string
+	for i := 0; i < len(opts); i++ {
+		opt := opts[i]
+		if !strings.HasPrefix(opt, "-") {
+			res = append(res, opt)
+			continue
+		}
+		key := strings.TrimLeft(opt, "-")
+		e := strings.Index(key, "=")
+		if e >= 0 {
+			key = key[0:e]
+		}
+		if key == "cache" || key == "profile" {
+			if e < 0 {
+				i++
+			}
+		} else {
+			res = append(res, opt)
+		}
+	}
+	return res
+}
+
+func (goofys *goofysMounter) Unmount(ctx context.Context, target, volumeID string) error {
+	return FuseUnmount(ctx, target, unmountTimeout(goofys.meta))
+}
+
+func (goofys *goofysMounter) Status(target, volumeID string) error {
+	return checkFuseMount(target)
+}
+
+func (goofys *goofysMounter) Capabilities() Capabilities {
+	return Capabilities{
+		Renames:  true,
+		ReadOnly: true,
+	}
+}
@@ -0,0 +1,78 @@
+package mounter
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/kubernetes/pkg/util/mount"
+
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/s3"
+)
+
+var _ = Describe("goofys mounter", func() {
+	var dir, target string
+	var fake *fakeMounter
+	var restore func()
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "goofys")
+		Expect(err).NotTo(HaveOccurred())
+		fake, restore = installFakeMounter(dir, goofysCmd)
+		target = filepath.Join(dir, "target")
+		Expect(os.Mkdir(target, 0755)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		restore()
+		mount.New("").Unmount(target)
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("translates parameters to flags and removes unsafe options", func() {
+		gid := 100
+		meta := &s3.FSMeta{
+			BucketName:   "bucket",
+			Prefix:       "prefix",
+			Mounter:      goofysMounterType,
+			DirMode:      0750,
+			FileMode:     0640,
+			Gid:          &gid,
+			ReadOnly:     true,
+			MountOptions: []string{"--profile", "default", "--cache=/tmp", "--stat-cache-ttl", "1m"},
+		}
+		m, err := New(meta, &s3.Config{Endpoint: "http://s3.local", AccessKeyID: "id", SecretAccessKey: "secret"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(m.Mount(context.Background(), target, "bucket/prefix")).To(Succeed())
+		Expect(fake.args()).To(Equal([]string{
+			"-f", "--endpoint", "http://s3.local", "-o", "allow_other",
+			"--dir-mode", "0750",
+			"--file-mode", "0640",
+			"--gid", "100",
+			"-o", "ro",
+			"--stat-cache-ttl", "1m",
+			"bucket:prefix", target,
+		}))
+		Expect(fake.env()).To(ContainElements("AWS_ACCESS_KEY_ID=id", "AWS_SECRET_ACCESS_KEY=secret"))
+
+		Expect(m.Unmount(context.Background(), target, "bucket/prefix")).To(Succeed())
+		Expect(m.Status(target, "bucket/prefix")).NotTo(Succeed())
+	})
+
+	It("mounts the whole bucket without a prefix", func() {
+		meta := &s3.FSMeta{BucketName: "bucket", Mounter: goofysMounterType}
+		m, err := New(meta, &s3.Config{Endpoint: "http://s3.local", Region: "us-east-1"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(m.Mount(context.Background(), target, "bucket")).To(Succeed())
+		Expect(fake.args()).To(Equal([]string{
+			"-f", "--endpoint", "http://s3.local", "-o", "allow_other",
+			"--region", "us-east-1",
+			"bucket", target,
+		}))
+		Expect(m.Unmount(context.Background(), target, "bucket")).To(Succeed())
+	})
+})
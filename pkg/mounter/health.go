@@ -0,0 +1,34 @@
+package mounter
+
+import (
+	"fmt"
+	"os/exec"
+	"sync"
+)
+
+var (
+	binariesMu sync.Mutex
+	// Binaries of exec mounters which must be present for the node to be ready,
+	// in addition to geesefs. Binaries of other built-in mounters are optional
+	// and aren't in the default image.
+	requiredBinaries []string
+)
+
+func requireBinary(path string) {
+	binariesMu.Lock()
+	defer binariesMu.Unlock()
+	requiredBinaries = append(requiredBinaries, path)
+}
+
+// CheckBinaries checks that binaries of the default mounter and of
+// exec mounters are available
+func CheckBinaries() error {
+	binariesMu.Lock()
+	defer binariesMu.Unlock()
+	for _, bin := range append([]string{currentSettings().GeesefsPath}, requiredBinaries...) {
+		if _, err := exec.LookPath(bin); err != nil {
+			return fmt.Errorf("Mounter binary %s is not available: %v", bin, err)
+		}
+	}
+	return nil
+}
@@ -1,75 +1,134 @@
 package mounter
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"math"
 	"os"
 	"os/exec"
-	"strings"
-	"syscall"
 	"time"
 
 	systemd "github.com/coreos/go-systemd/v22/dbus"
-	"github.com/golang/glog"
-	"github.com/mitchellh/go-ps"
+	"go.opentelemetry.io/otel/attribute"
 	"k8s.io/kubernetes/pkg/util/mount"
 
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/logging"
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/metrics"
 	"github.com/yandex-cloud/k8s-csi-s3/pkg/s3"
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/tracing"
 )
 
 // Mounter interface which can be implemented
 // by the different mounter types
 type Mounter interface {
-	Mount(target, volumeID string) error
+	// Mount mounts the volume at target. ctx only carries the trace
+	// span, the mount isn't cancelled with it.
+	Mount(ctx context.Context, target, volumeID string) error
+	// Unmount unmounts target and stops the process serving it
+	Unmount(ctx context.Context, target, volumeID string) error
+	// Status returns an error if the volume isn't mounted at target
+	// or the mount is broken
+	Status(target, volumeID string) error
+	Capabilities() Capabilities
+}
+
+// Capabilities describe features supported by a mounter
+type Capabilities struct {
+	// POSIX features of the file system
+	Renames      bool
+	Symlinks     bool
+	Permissions  bool
+	RandomWrites bool
+	// Volumes may be mounted in read-only mode
+	ReadOnly bool
+	// Volumes may use the node disk cache
+	Cache bool
 }
 
 const (
-	s3fsMounterType     = "s3fs"
-	geesefsMounterType  = "geesefs"
-	rcloneMounterType   = "rclone"
-	TypeKey             = "mounter"
-	BucketKey           = "bucket"
-	OptionsKey          = "options"
+	s3fsMounterType         = "s3fs"
+	geesefsMounterType      = "geesefs"
+	rcloneMounterType       = "rclone"
+	mountpointS3MounterType = "mountpoint-s3"
+	goofysMounterType       = "goofys"
+	TypeKey                 = "mounter"
+	BucketKey               = "bucket"
+	PrefixKey               = "prefix"
+	OptionsKey              = "options"
 )
 
+// ErrMountTimeout is returned when the mounter doesn't mount the volume in time
+var ErrMountTimeout = errors.New("Timeout waiting for mount")
+
+// errMountWaitStopped is returned when waiting for a mount is no longer needed
+var errMountWaitStopped = errors.New("Stopped waiting for mount")
+
 // New returns a new mounter depending on the mounterType parameter
 func New(meta *s3.FSMeta, cfg *s3.Config) (Mounter, error) {
-	mounter := meta.Mounter
-	// Fall back to mounterType in cfg
-	if len(meta.Mounter) == 0 {
-		mounter = cfg.Mounter
+	name := mounterType(meta, cfg)
+	r, ok := lookup(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown mounter %q", name)
+	}
+	m, err := r.factory(meta, cfg)
+	if err != nil {
+		return nil, err
 	}
-	switch mounter {
-	case geesefsMounterType:
-		return newGeeseFSMounter(meta, cfg)
+	return &instrumentedMounter{Mounter: m, name: name}, nil
+}
 
-	case s3fsMounterType:
-		return newS3fsMounter(meta, cfg)
+// instrumentedMounter records durations and failures of mount operations
+// in metrics and trace spans
+type instrumentedMounter struct {
+	Mounter
+	name string
+}
 
-	case rcloneMounterType:
-		return newRcloneMounter(meta, cfg)
+func (m *instrumentedMounter) Mount(ctx context.Context, target, volumeID string) error {
+	start := time.Now()
+	ctx, span := tracing.Start(ctx, "Mount", m.attributes(target, volumeID)...)
+	err := m.Mounter.Mount(ctx, target, volumeID)
+	tracing.End(span, err)
+	metrics.ObserveMount(m.name, "mount", start, err)
+	return err
+}
 
-	default:
-		// default to GeeseFS
-		return newGeeseFSMounter(meta, cfg)
-	}
+func (m *instrumentedMounter) Unmount(ctx context.Context, target, volumeID string) error {
+	start := time.Now()
+	ctx, span := tracing.Start(ctx, "Unmount", m.attributes(target, volumeID)...)
+	err := m.Mounter.Unmount(ctx, target, volumeID)
+	tracing.End(span, err)
+	metrics.ObserveMount(m.name, "unmount", start, err)
+	return err
 }
 
-func fuseMount(path string, command string, args []string, envs []string) error {
-	cmd := exec.Command(command, args...)
-	cmd.Stderr = os.Stderr
-	// cmd.Environ() returns envs inherited from the current process
-	cmd.Env = append(cmd.Environ(), envs...)
-	glog.V(3).Infof("Mounting fuse with command: %s and args: %s", command, args)
+func (m *instrumentedMounter) attributes(target, volumeID string) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("mounter", m.name),
+		attribute.String("volume.id", volumeID),
+		attribute.String("mount.target", target),
+	}
+}
 
-	out, err := cmd.Output()
-	if err != nil {
-		return fmt.Errorf("Error fuseMount command: %s\nargs: %s\noutput: %s", command, args, out)
+func mounterType(meta *s3.FSMeta, cfg *s3.Config) string {
+	mounter := meta.Mounter
+	// Fall back to mounterType in cfg
+	if len(mounter) == 0 && cfg != nil {
+		mounter = cfg.Mounter
 	}
+	if len(mounter) == 0 {
+		mounter = geesefsMounterType
+	}
+	return mounter
+}
 
-	return waitForMount(path, 10*time.Second)
+// fuseMount starts a FUSE process in foreground under supervision
+// so that it gets restarted if it crashes
+func fuseMount(ctx context.Context, path string, volumeID string, command string, args []string, envs []string, timeout time.Duration) error {
+	ctx, span := tracing.Start(ctx, "Start FUSE process", attribute.String("command", command))
+	err := fuseSupervisor.start(ctx, volumeID, path, command, args, envs, timeout)
+	tracing.End(span, err)
+	return err
 }
 
 func Unmount(path string) error {
@@ -79,46 +138,109 @@ func Unmount(path string) error {
 	return nil
 }
 
-func SystemdUnmount(volumeID string) (bool, error) {
+// Detect returns the mounter serving the volume at target when it's unknown
+// because the driver has been restarted. Mounters running inside the
+// container don't survive restarts, so only systemd units are checked.
+// The unmount timeout of the volume is restored from the stop timeout of the
+// unit, stale mounts of other mounters are unmounted with the node default.
+func Detect(volumeID, target string) Mounter {
 	conn, err := systemd.New()
-	if err != nil {
-		glog.Errorf("Failed to connect to systemd dbus service: %v", err)
-		return false, err
+	if err == nil {
+		defer conn.Close()
+		for command, mounterType := range systemdCommands {
+			unitProps, err := conn.GetAllProperties(systemdUnitName(command, volumeID))
+			if err != nil {
+				continue
+			}
+			if s, ok := unitProps["ActiveState"].(string); !ok || s == "inactive" || s == "failed" ||
+				systemdUnitTarget(unitProps) != target {
+				continue
+			}
+			if r, ok := lookup(mounterType); ok {
+				meta := &s3.FSMeta{Mounter: mounterType, UnmountTimeout: systemdUnitStopTimeout(unitProps)}
+				if m, err := r.factory(meta, &s3.Config{}); err == nil {
+					return &instrumentedMounter{Mounter: m, name: mounterType}
+				}
+			}
+		}
 	}
-	defer conn.Close()
-	unitName := "geesefs-"+systemd.PathBusEscape(volumeID)+".service"
-	units, err := conn.ListUnitsByNames([]string{ unitName })
-	glog.Errorf("Got %v", units)
+	return &instrumentedMounter{Mounter: &orphanMounter{}, name: "unknown"}
+}
+
+// orphanMounter unmounts FUSE mounts of unknown mounters
+type orphanMounter struct{}
+
+func (m *orphanMounter) Mount(ctx context.Context, target, volumeID string) error {
+	return fmt.Errorf("mounter of volume %s is unknown", volumeID)
+}
+
+func (m *orphanMounter) Unmount(ctx context.Context, target, volumeID string) error {
+	return FuseUnmount(ctx, target, currentSettings().UnmountTimeout)
+}
+
+func (m *orphanMounter) Status(target, volumeID string) error {
+	return checkFuseMount(target)
+}
+
+func (m *orphanMounter) Capabilities() Capabilities {
+	return Capabilities{}
+}
+
+// checkFuseMount returns an error if target is not a mountpoint
+// or its FUSE process is not responding
+func checkFuseMount(target string) error {
+	notMnt, err := mount.New("").IsLikelyNotMountPoint(target)
 	if err != nil {
-		glog.Errorf("Failed to list systemd unit by name %v: %v", unitName, err)
-		return false, err
+		if mount.IsCorruptedMnt(err) {
+			return fmt.Errorf("Mount at %s is broken: %v", target, err)
+		}
+		return err
 	}
-	if len(units) == 0 || units[0].ActiveState == "inactive" || units[0].ActiveState == "failed" {
-		return true, nil
+	if notMnt {
+		return fmt.Errorf("%s is not mounted", target)
 	}
-	_, err = conn.StopUnit(unitName, "replace", nil)
-	return true, err
+	return nil
 }
 
-func FuseUnmount(path string) error {
-	if err := mount.New("").Unmount(path); err != nil {
-		return err
+// HasFuseProcess checks if path is served by a FUSE process or a systemd
+// unit started for this volume and path, and not just bind-mounted from
+// the staging path
+func HasFuseProcess(volumeID, path string) (bool, error) {
+	proc, err := FindFuseMountProcess(path)
+	if err != nil {
+		return false, err
+	}
+	if proc != nil {
+		return true, nil
 	}
-	// as fuse quits immediately, we will try to wait until the process is done
-	process, err := FindFuseMountProcess(path)
+	conn, err := systemd.New()
 	if err != nil {
-		glog.Errorf("Error getting PID of fuse mount: %s", err)
-		return nil
+		// No systemd, so no systemd units
+		return false, nil
 	}
-	if process == nil {
-		glog.Warningf("Unable to find PID of fuse mount %s, it must have finished already", path)
-		return nil
+	defer conn.Close()
+	for command := range systemdCommands {
+		unitProps, err := conn.GetAllProperties(systemdUnitName(command, volumeID))
+		if err != nil {
+			continue
+		}
+		if s, ok := unitProps["ActiveState"].(string); !ok || s == "inactive" || s == "failed" {
+			continue
+		}
+		if systemdUnitTarget(unitProps) == path {
+			return true, nil
+		}
 	}
-	glog.Infof("Found fuse pid %v of mount %s, checking if it still runs", process.Pid, path)
-	return waitForProcess(process, 20)
+	return false, nil
 }
 
 func waitForMount(path string, timeout time.Duration) error {
+	return waitForMountUntil(path, timeout, nil)
+}
+
+// waitForMountUntil waits for the mountpoint like waitForMount,
+// but gives up with errMountWaitStopped when stop is closed
+func waitForMountUntil(path string, timeout time.Duration, stop <-chan struct{}) error {
 	var elapsed time.Duration
 	var interval = 10 * time.Millisecond
 	for {
@@ -129,65 +251,38 @@ func waitForMount(path string, timeout time.Duration) error {
 		if !notMount {
 			return nil
 		}
-		time.Sleep(interval)
+		select {
+		case <-stop:
+			return errMountWaitStopped
+		case <-time.After(interval):
+		}
 		elapsed = elapsed + interval
 		if elapsed >= timeout {
-			return errors.New("Timeout waiting for mount")
+			return ErrMountTimeout
 		}
 	}
 }
 
+// FindFuseMountProcess returns the FUSE process serving the mount at exactly
+// path. Processes started by the driver are known by their PIDs, others are
+// found by a command line argument equal to path and an open /dev/fuse.
 func FindFuseMountProcess(path string) (*os.Process, error) {
-	processes, err := ps.Processes()
+	if pid := fuseSupervisor.pid(path); pid != 0 {
+		return os.FindProcess(pid)
+	}
+	mi, err := findMount(path)
 	if err != nil {
 		return nil, err
 	}
-	for _, p := range processes {
-		cmdLine, err := getCmdLine(p.Pid())
-		if err != nil {
-			glog.Errorf("Unable to get cmdline of PID %v: %s", p.Pid(), err)
-			continue
-		}
-		if strings.Contains(cmdLine, path) {
-			glog.Infof("Found matching pid %v on path %s", p.Pid(), path)
-			return os.FindProcess(p.Pid())
-		}
-	}
-	return nil, nil
-}
-
-func waitForProcess(p *os.Process, limit int) error {
-	for backoff := 0; backoff < limit; backoff++ {
-		cmdLine, err := getCmdLine(p.Pid)
-		if err != nil {
-			glog.Warningf("Error checking cmdline of PID %v, assuming it is dead: %s", p.Pid, err)
-			p.Wait()
-			return nil
-		}
-		if cmdLine == "" {
-			glog.Warning("Fuse process seems dead, returning")
-			p.Wait()
-			return nil
-		}
-		if err := p.Signal(syscall.Signal(0)); err != nil {
-			glog.Warningf("Fuse process does not seem active or we are unprivileged: %s", err)
-			p.Wait()
-			return nil
-		}
-		glog.Infof("Fuse process with PID %v still active, waiting...", p.Pid)
-		time.Sleep(time.Duration(math.Pow(1.5, float64(backoff))*100) * time.Millisecond)
+	if mi == nil || !mi.isFuse() {
+		return nil, nil
 	}
-	p.Release()
-	return fmt.Errorf("Timeout waiting for PID %v to end", p.Pid)
-}
-
-func getCmdLine(pid int) (string, error) {
-	cmdLineFile := fmt.Sprintf("/proc/%v/cmdline", pid)
-	cmdLine, err := ioutil.ReadFile(cmdLineFile)
-	if err != nil {
-		return "", err
+	pid, err := findFuseProcess(path)
+	if err != nil || pid == 0 {
+		return nil, err
 	}
-	return string(cmdLine), nil
+	logging.Infof("Found matching pid %v on path %s", pid, path)
+	return os.FindProcess(pid)
 }
 
 func createLoopDevice(device string) error {
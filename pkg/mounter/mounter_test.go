@@ -0,0 +1,83 @@
+package mounter
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/kubernetes/pkg/util/mount"
+
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/s3"
+)
+
+func TestMounter(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Mounter")
+}
+
+// fakeMounterScript records its arguments and environment next to itself,
+// mounts a tmpfs at the target, which is the last argument, and stays in
+// foreground until it's unmounted
+const fakeMounterScript = `#!/bin/sh
+printf '%s\n' "$@" > "$0.args"
+env > "$0.env"
+for target; do :; done
+mount -t tmpfs none "$target" || exit 1
+while mountpoint -q "$target"; do sleep 0.1; done
+`
+
+// fakeMounter is a FUSE binary replaced by fakeMounterScript
+type fakeMounter struct {
+	path string
+}
+
+// installFakeMounter writes fakeMounterScript as command into dir and puts
+// dir first in PATH until restore is called. Tests using it are skipped if
+// mounting isn't permitted.
+func installFakeMounter(dir, command string) (f *fakeMounter, restore func()) {
+	probe := filepath.Join(dir, "probe")
+	Expect(os.Mkdir(probe, 0755)).To(Succeed())
+	if err := exec.Command("mount", "-t", "tmpfs", "none", probe).Run(); err != nil {
+		Skip("mounting is not permitted: " + err.Error())
+	}
+	Expect(mount.New("").Unmount(probe)).To(Succeed())
+	f = &fakeMounter{path: filepath.Join(dir, command)}
+	Expect(ioutil.WriteFile(f.path, []byte(fakeMounterScript), 0755)).To(Succeed())
+	path := os.Getenv("PATH")
+	Expect(os.Setenv("PATH", dir+":"+path)).To(Succeed())
+	return f, func() {
+		os.Setenv("PATH", path)
+	}
+}
+
+func (f *fakeMounter) args() []string {
+	buf, err := ioutil.ReadFile(f.path + ".args")
+	Expect(err).NotTo(HaveOccurred())
+	return strings.Split(strings.TrimSuffix(string(buf), "\n"), "\n")
+}
+
+func (f *fakeMounter) env() []string {
+	buf, err := ioutil.ReadFile(f.path + ".env")
+	Expect(err).NotTo(HaveOccurred())
+	return strings.Split(string(buf), "\n")
+}
+
+var _ = Describe("New", func() {
+	It("rejects unknown mounters", func() {
+		_, err := New(&s3.FSMeta{Mounter: "unknown"}, &s3.Config{})
+		Expect(err).To(MatchError(`unknown mounter "unknown"`))
+		_, err = New(&s3.FSMeta{}, &s3.Config{Mounter: "unknown"})
+		Expect(err).To(MatchError(`unknown mounter "unknown"`))
+	})
+
+	It("defaults to geesefs", func() {
+		m, err := New(&s3.FSMeta{}, &s3.Config{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(m.(*instrumentedMounter).name).To(Equal(geesefsMounterType))
+	})
+})
@@ -0,0 +1,155 @@
+package mounter
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	systemd "github.com/coreos/go-systemd/v22/dbus"
+
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/logging"
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/s3"
+)
+
+const (
+	mountpointS3Cmd = "mount-s3"
+)
+
+// Implements Mounter
+type mountpointS3Mounter struct {
+	meta            *s3.FSMeta
+	endpoint        string
+	region          string
+	accessKeyID     string
+	secretAccessKey string
+}
+
+func init() {
+	Register(mountpointS3MounterType, newMountpointS3Mounter, DirModeKey, FileModeKey, UidKey, GidKey, CacheSizeKey)
+}
+
+func newMountpointS3Mounter(meta *s3.FSMeta, cfg *s3.Config) (Mounter, error) {
+	return &mountpointS3Mounter{
+		meta:            meta,
+		endpoint:        cfg.Endpoint,
+		region:          cfg.Region,
+		accessKeyID:     cfg.AccessKeyID,
+		secretAccessKey: cfg.SecretAccessKey,
+	}, nil
+}
+
+func (mp *mountpointS3Mounter) Mount(ctx context.Context, target, volumeID string) error {
+	args := []string{
+		"-f",
+		"--allow-other",
+	}
+	readOnly := mp.meta.ReadOnly
+	for _, opt := range mp.meta.MountOptions {
+		// --read-only conflicts with --allow-delete and --allow-overwrite
+		if opt == "--read-only" {
+			readOnly = true
+		}
+	}
+	if readOnly {
+		args = append(args, "--read-only")
+	} else {
+		args = append(args, "--allow-delete", "--allow-overwrite")
+	}
+	if mp.endpoint != "" {
+		args = append(args, "--endpoint-url", mp.endpoint, "--force-path-style")
+	}
+	if mp.region != "" {
+		args = append(args, "--region", mp.region)
+	}
+	if mp.meta.Prefix != "" {
+		// mountpoint-s3 requires the prefix to end with a slash
+		args = append(args, "--prefix", strings.TrimSuffix(mp.meta.Prefix, "/")+"/")
+	}
+	if mp.meta.DirMode != 0 {
+		args = append(args, "--dir-mode", fmt.Sprintf("%04o", uint32(mp.meta.DirMode)))
+	}
+	if mp.meta.FileMode != 0 {
+		args = append(args, "--file-mode", fmt.Sprintf("%04o", uint32(mp.meta.FileMode)))
+	}
+	if mp.meta.Uid != nil {
+		args = append(args, "--uid", fmt.Sprintf("%d", *mp.meta.Uid))
+	}
+	if mp.meta.Gid != nil {
+		args = append(args, "--gid", fmt.Sprintf("%d", *mp.meta.Gid))
+	}
+	if mp.meta.CacheDir != "" {
+		args = append(
+			args,
+			"--cache", mp.meta.CacheDir,
+			"--max-cache-size", fmt.Sprintf("%d", ceilDiv(mp.meta.CacheSize, 1<<20)),
+		)
+	}
+	opts, useSystemd := mountpointS3SafeOptions(mp.meta.MountOptions)
+	args = append(args, opts...)
+	args = append(args, mp.meta.BucketName, target)
+	envs := []string{
+		"AWS_ACCESS_KEY_ID=" + mp.accessKeyID,
+		"AWS_SECRET_ACCESS_KEY=" + mp.secretAccessKey,
+	}
+	// Try to start mount-s3 using systemd so it doesn't get killed when the container exits
+	if !useSystemd {
+		return fuseMount(ctx, target, volumeID, mountpointS3Cmd, args, envs, mountTimeout(mp.meta))
+	}
+	conn, err := systemd.New()
+	if err != nil {
+		logging.FromContext(ctx).Errorf("Failed to connect to systemd dbus service: %v, starting mount-s3 directly", err)
+		return fuseMount(ctx, target, volumeID, mountpointS3Cmd, args, envs, mountTimeout(mp.meta))
+	}
+	defer conn.Close()
+	return systemdMount(ctx, conn, mountpointS3Cmd, "Mountpoint for Amazon S3", volumeID, target, args, envs, mp.meta)
+}
+
+// mountpointS3SafeOptions removes options accessing the local FS and options
+// always set by the driver because mount-s3 rejects repeated flags. It also
+// reports whether --no-systemd was not given.
+func mountpointS3SafeOptions(opts []string) ([]string, bool) {
+	var res []string
+	useSystemd := true
+	for i := 0; i < len(opts); i++ {
+		opt := opts[i]
+		if opt == "--no-systemd" {
+			useSystemd = false
+			continue
+		}
+		if !strings.HasPrefix(opt, "-") {
+			res = append(res, opt)
+			continue
+		}
+		key := strings.TrimLeft(opt, "-")
+		e := strings.Index(key, "=")
+		if e >= 0 {
+			key = key[0:e]
+		}
+		switch key {
+		case "cache", "max-cache-size", "log-directory", "profile":
+			// The cache directory is managed by the driver
+			if e < 0 {
+				i++
+			}
+		case "f", "foreground", "allow-other", "allow-delete", "allow-overwrite", "read-only":
+		default:
+			res = append(res, opt)
+		}
+	}
+	return res, useSystemd
+}
+
+func (mp *mountpointS3Mounter) Unmount(ctx context.Context, target, volumeID string) error {
+	return systemdOrFuseUnmount(ctx, mountpointS3Cmd, target, volumeID, unmountTimeout(mp.meta))
+}
+
+func (mp *mountpointS3Mounter) Status(target, volumeID string) error {
+	return checkFuseMount(target)
+}
+
+func (mp *mountpointS3Mounter) Capabilities() Capabilities {
+	return Capabilities{
+		ReadOnly: true,
+		Cache:    true,
+	}
+}
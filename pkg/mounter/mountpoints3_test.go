@@ -0,0 +1,110 @@
+package mounter
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/kubernetes/pkg/util/mount"
+
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/s3"
+)
+
+var _ = Describe("mountpoint-s3 mounter", func() {
+	var dir, target string
+	var fake *fakeMounter
+	var restore func()
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "mountpoint-s3")
+		Expect(err).NotTo(HaveOccurred())
+		fake, restore = installFakeMounter(dir, mountpointS3Cmd)
+		target = filepath.Join(dir, "target")
+		Expect(os.Mkdir(target, 0755)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		restore()
+		mount.New("").Unmount(target)
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	cfg := &s3.Config{
+		Endpoint:        "http://s3.local",
+		Region:          "ru-central1",
+		AccessKeyID:     "id",
+		SecretAccessKey: "secret",
+	}
+
+	It("translates parameters to flags", func() {
+		uid := 1000
+		meta := &s3.FSMeta{
+			BucketName: "bucket",
+			Prefix:     "some/prefix",
+			Mounter:    mountpointS3MounterType,
+			DirMode:    0755,
+			FileMode:   0644,
+			Uid:        &uid,
+			MountOptions: []string{
+				"--no-systemd", "--allow-other", "--cache", "/etc", "--log-directory=/tmp", "--maximum-throughput-gbps", "1",
+			},
+		}
+		m, err := New(meta, cfg)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(m.Mount(context.Background(), target, "bucket/some/prefix")).To(Succeed())
+		Expect(m.Status(target, "bucket/some/prefix")).To(Succeed())
+		Expect(fake.args()).To(Equal([]string{
+			"-f", "--allow-other", "--allow-delete", "--allow-overwrite",
+			"--endpoint-url", "http://s3.local", "--force-path-style",
+			"--region", "ru-central1",
+			"--prefix", "some/prefix/",
+			"--dir-mode", "0755",
+			"--file-mode", "0644",
+			"--uid", "1000",
+			"--maximum-throughput-gbps", "1",
+			"bucket", target,
+		}))
+		Expect(fake.env()).To(ContainElements("AWS_ACCESS_KEY_ID=id", "AWS_SECRET_ACCESS_KEY=secret"))
+
+		Expect(m.Unmount(context.Background(), target, "bucket/some/prefix")).To(Succeed())
+		Expect(m.Status(target, "bucket/some/prefix")).NotTo(Succeed())
+	})
+
+	It("mounts read-only without delete and overwrite", func() {
+		meta := &s3.FSMeta{
+			BucketName:   "bucket",
+			Mounter:      mountpointS3MounterType,
+			MountOptions: []string{"--no-systemd", "--read-only"},
+		}
+		m, err := New(meta, cfg)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(m.Mount(context.Background(), target, "bucket")).To(Succeed())
+		Expect(fake.args()).To(Equal([]string{
+			"-f", "--allow-other", "--read-only",
+			"--endpoint-url", "http://s3.local", "--force-path-style",
+			"--region", "ru-central1",
+			"bucket", target,
+		}))
+		Expect(m.Unmount(context.Background(), target, "bucket")).To(Succeed())
+	})
+
+	It("passes the disk cache in megabytes", func() {
+		meta := &s3.FSMeta{
+			BucketName:   "bucket",
+			Mounter:      mountpointS3MounterType,
+			CacheDir:     filepath.Join(dir, "cache"),
+			CacheSize:    1<<30 + 1,
+			MountOptions: []string{"--no-systemd"},
+		}
+		m, err := New(meta, cfg)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(m.Capabilities().Cache).To(BeTrue())
+		Expect(m.Mount(context.Background(), target, "bucket")).To(Succeed())
+		Expect(fake.args()).To(ContainElements("--cache", meta.CacheDir, "--max-cache-size", "1025"))
+		Expect(m.Unmount(context.Background(), target, "bucket")).To(Succeed())
+	})
+})
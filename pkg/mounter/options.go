@@ -0,0 +1,284 @@
+package mounter
+
+import (
+	"fmt"
+	"math/big"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/s3"
+)
+
+const (
+	CapacityKey    = "capacity"
+	MemoryLimitKey = "memoryLimit"
+	ReadAheadKey   = "readAhead"
+	DirModeKey     = "dirMode"
+	FileModeKey    = "fileMode"
+	UidKey         = "uid"
+	GidKey         = "gid"
+	CacheSizeKey   = "cacheSize"
+	// Supported by all mounters
+	MountTimeoutKey   = "mountTimeout"
+	UnmountTimeoutKey = "unmountTimeout"
+)
+
+// plainNumber matches sizes without a suffix, which are in units of the parameter
+var plainNumber = regexp.MustCompile(`^[0-9]*\.?[0-9]+$`)
+
+// ValidateParams checks StorageClass parameters so that invalid ones are
+// rejected when the volume is created and not when it's mounted on a node.
+// cfg is built from the same secrets as on the node to select the mounter.
+func ValidateParams(params map[string]string, cfg *s3.Config) error {
+	meta := &s3.FSMeta{
+		Mounter: params[TypeKey],
+	}
+	return ParseParams(meta, cfg, params)
+}
+
+// ParseParams fills mount options of meta from the "options" string and
+// typed volume parameters and checks that the mounter selected by meta
+// or cfg supports them
+func ParseParams(meta *s3.FSMeta, cfg *s3.Config, params map[string]string) error {
+	var err error
+	meta.MountOptions, err = SplitOptions(params[OptionsKey])
+	if err != nil {
+		return fmt.Errorf("invalid %s: %v", OptionsKey, err)
+	}
+	if meta.CapacityBytes, err = parseSize(params, CapacityKey, 1); err != nil {
+		return err
+	}
+	if meta.MemoryLimit, err = parseSize(params, MemoryLimitKey, 1<<20); err != nil {
+		return err
+	}
+	if meta.ReadAhead, err = parseSize(params, ReadAheadKey, 1<<10); err != nil {
+		return err
+	}
+	if meta.CacheSize, err = parseSize(params, CacheSizeKey, 1<<20); err != nil {
+		return err
+	}
+	if meta.DirMode, err = parseMode(params, DirModeKey); err != nil {
+		return err
+	}
+	if meta.FileMode, err = parseMode(params, FileModeKey); err != nil {
+		return err
+	}
+	if meta.Uid, err = parseID(params, UidKey); err != nil {
+		return err
+	}
+	if meta.Gid, err = parseID(params, GidKey); err != nil {
+		return err
+	}
+	if meta.MountTimeout, err = parseTimeout(params, MountTimeoutKey); err != nil {
+		return err
+	}
+	if meta.UnmountTimeout, err = parseTimeout(params, UnmountTimeoutKey); err != nil {
+		return err
+	}
+	return checkSupportedParams(mounterType(meta, cfg), params)
+}
+
+// ApplyMountGroup makes the volume accessible for the group from
+// VolumeMountGroup of a CSI request which is usually the pod's fsGroup
+func ApplyMountGroup(meta *s3.FSMeta, group string) error {
+	if group == "" {
+		return nil
+	}
+	gid, err := strconv.ParseUint(group, 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid volume mount group %q: must be a numeric GID", group)
+	}
+	meta.Gid = intPtr(int(gid))
+	// Grant write access to the group unless permissions are set explicitly
+	if meta.DirMode == 0 {
+		meta.DirMode = 0775
+	}
+	if meta.FileMode == 0 {
+		meta.FileMode = 0664
+	}
+	return nil
+}
+
+func checkSupportedParams(mounter string, params map[string]string) error {
+	r, ok := lookup(mounter)
+	if !ok {
+		return fmt.Errorf("unknown mounter %q", mounter)
+	}
+	var unsupported []string
+	for _, key := range []string{MemoryLimitKey, ReadAheadKey, DirModeKey, FileModeKey, UidKey, GidKey, CacheSizeKey} {
+		if params[key] == "" {
+			continue
+		}
+		found := false
+		for _, s := range r.params {
+			if s == key {
+				found = true
+				break
+			}
+		}
+		if !found {
+			unsupported = append(unsupported, key)
+		}
+	}
+	if len(unsupported) > 0 {
+		sort.Strings(unsupported)
+		return fmt.Errorf("mounter %s does not support parameters: %s", mounter, strings.Join(unsupported, ", "))
+	}
+	return nil
+}
+
+// parseSize parses a Kubernetes resource quantity like "10Gi" or "500M" and
+// returns it in bytes. Plain numbers like "1000" or "1.5" are multiplied by
+// unit for compatibility with parameters which were previously set in
+// megabytes or kilobytes, fractions of bytes are rounded up.
+func parseSize(params map[string]string, key string, unit int64) (int64, error) {
+	str := params[key]
+	if str == "" {
+		return 0, nil
+	}
+	if plainNumber.MatchString(str) {
+		r, ok := new(big.Rat).SetString(str)
+		if !ok {
+			return 0, fmt.Errorf("invalid %s %q: must be a quantity like 512Mi or 10Gi", key, str)
+		}
+		r.Mul(r, new(big.Rat).SetInt64(unit))
+		v := new(big.Int).Add(r.Num(), new(big.Int).Sub(r.Denom(), big.NewInt(1)))
+		v.Quo(v, r.Denom())
+		if !v.IsInt64() {
+			return 0, fmt.Errorf("invalid %s %q: out of range", key, str)
+		}
+		return v.Int64(), nil
+	}
+	q, err := resource.ParseQuantity(str)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: must be a quantity like 512Mi or 10Gi", key, str)
+	}
+	if q.Sign() < 0 {
+		return 0, fmt.Errorf("invalid %s %q: must not be negative", key, str)
+	}
+	return q.Value(), nil
+}
+
+// parseTimeout parses a duration like "90s" or "2m", plain numbers are seconds
+func parseTimeout(params map[string]string, key string) (time.Duration, error) {
+	str := params[key]
+	if str == "" {
+		return 0, nil
+	}
+	if _, err := strconv.ParseUint(str, 10, 32); err == nil {
+		str += "s"
+	}
+	d, err := time.ParseDuration(str)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: must be a duration like 90s or 2m", key, params[key])
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("invalid %s %q: must be positive", key, params[key])
+	}
+	return d, nil
+}
+
+func mountTimeout(meta *s3.FSMeta) time.Duration {
+	if meta.MountTimeout > 0 {
+		return meta.MountTimeout
+	}
+	return currentSettings().MountTimeout
+}
+
+func unmountTimeout(meta *s3.FSMeta) time.Duration {
+	if meta.UnmountTimeout > 0 {
+		return meta.UnmountTimeout
+	}
+	return currentSettings().UnmountTimeout
+}
+
+// ceilDiv converts a size in bytes to the given unit rounding up
+func ceilDiv(size, unit int64) int64 {
+	return (size + unit - 1) / unit
+}
+
+// parseID parses a UID or GID, it returns nil if it's not set so that
+// 0 (root) can be set explicitly
+func parseID(params map[string]string, key string) (*int, error) {
+	str := params[key]
+	if str == "" {
+		return nil, nil
+	}
+	v, err := strconv.ParseUint(str, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q: must be a non-negative integer", key, str)
+	}
+	return intPtr(int(v)), nil
+}
+
+func intPtr(v int) *int {
+	return &v
+}
+
+func parseMode(params map[string]string, key string) (os.FileMode, error) {
+	str := params[key]
+	if str == "" {
+		return 0, nil
+	}
+	v, err := strconv.ParseUint(str, 8, 32)
+	if err != nil || v > 0777 {
+		return 0, fmt.Errorf("invalid %s %q: must be an octal permission mask like 0755", key, str)
+	}
+	return os.FileMode(v), nil
+}
+
+// SplitOptions splits a mount option string into separate arguments.
+// Arguments are separated by whitespace and may be quoted with single
+// or double quotes. A backslash escapes the next character everywhere
+// except inside single quotes.
+func SplitOptions(str string) ([]string, error) {
+	var opts []string
+	var cur strings.Builder
+	inArg := false
+	var quote rune
+	escaped := false
+	for _, c := range str {
+		switch {
+		case escaped:
+			cur.WriteRune(c)
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped = true
+			inArg = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				cur.WriteRune(c)
+			}
+		case c == '"' || c == '\'':
+			quote = c
+			inArg = true
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if inArg {
+				opts = append(opts, cur.String())
+				cur.Reset()
+				inArg = false
+			}
+		default:
+			cur.WriteRune(c)
+			inArg = true
+		}
+	}
+	if escaped {
+		return nil, fmt.Errorf("trailing backslash in %q", str)
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote in %q", quote, str)
+	}
+	if inArg {
+		opts = append(opts, cur.String())
+	}
+	return opts, nil
+}
@@ -0,0 +1,189 @@
+package mounter
+
+import (
+	"os"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/s3"
+)
+
+var _ = Describe("SplitOptions", func() {
+	table.DescribeTable("splits options",
+		func(str string, expected []string) {
+			opts, err := SplitOptions(str)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(opts).To(Equal(expected))
+		},
+		table.Entry("empty", "", nil),
+		table.Entry("whitespace", " \t\n", nil),
+		table.Entry("plain", "--memory-limit 1000  --dir-mode=0777", []string{"--memory-limit", "1000", "--dir-mode=0777"}),
+		table.Entry("double quotes", `--opt "a b" c"d e"`, []string{"--opt", "a b", "cd e"}),
+		table.Entry("single quotes", `'a\ b' "'"`, []string{`a\ b`, "'"}),
+		table.Entry("escapes", `a\ b \"c\" "d\"e"`, []string{"a b", `"c"`, `d"e`}),
+		table.Entry("empty quoted argument", `--opt "" x`, []string{"--opt", "", "x"}),
+	)
+
+	table.DescribeTable("rejects invalid options",
+		func(str string) {
+			_, err := SplitOptions(str)
+			Expect(err).To(HaveOccurred())
+		},
+		table.Entry("trailing backslash", `a \`),
+		table.Entry("unterminated double quote", `"a b`),
+		table.Entry("unterminated single quote", `a 'b`),
+	)
+})
+
+var _ = Describe("ParseParams", func() {
+	It("parses typed parameters", func() {
+		meta := &s3.FSMeta{Mounter: geesefsMounterType}
+		Expect(ParseParams(meta, nil, map[string]string{
+			OptionsKey:        "--no-systemd --debug",
+			CapacityKey:       "1073741824",
+			MemoryLimitKey:    "1000",
+			ReadAheadKey:      "1Mi",
+			CacheSizeKey:      "10Gi",
+			DirModeKey:        "0775",
+			FileModeKey:       "644",
+			UidKey:            "1000",
+			GidKey:            "2000",
+			MountTimeoutKey:   "90",
+			UnmountTimeoutKey: "2m",
+		})).To(Succeed())
+		Expect(meta.MountOptions).To(Equal([]string{"--no-systemd", "--debug"}))
+		Expect(meta.CapacityBytes).To(Equal(int64(1 << 30)))
+		Expect(meta.MemoryLimit).To(Equal(int64(1000 << 20)))
+		Expect(meta.ReadAhead).To(Equal(int64(1 << 20)))
+		Expect(meta.CacheSize).To(Equal(int64(10 << 30)))
+		Expect(meta.DirMode).To(Equal(os.FileMode(0775)))
+		Expect(meta.FileMode).To(Equal(os.FileMode(0644)))
+		Expect(*meta.Uid).To(Equal(1000))
+		Expect(*meta.Gid).To(Equal(2000))
+		Expect(meta.MountTimeout).To(Equal(90 * time.Second))
+		Expect(meta.UnmountTimeout).To(Equal(2 * time.Minute))
+	})
+
+	It("keeps unset parameters at mounter defaults", func() {
+		meta := &s3.FSMeta{}
+		Expect(ParseParams(meta, nil, nil)).To(Succeed())
+		Expect(meta).To(Equal(&s3.FSMeta{}))
+	})
+
+	It("sets uid and gid 0", func() {
+		meta := &s3.FSMeta{}
+		Expect(ParseParams(meta, nil, map[string]string{UidKey: "0", GidKey: "0"})).To(Succeed())
+		Expect(meta.Uid).To(Equal(intPtr(0)))
+		Expect(meta.Gid).To(Equal(intPtr(0)))
+	})
+
+	table.DescribeTable("rejects invalid parameters",
+		func(key, value string) {
+			err := ParseParams(&s3.FSMeta{}, nil, map[string]string{key: value})
+			Expect(err).To(MatchError(ContainSubstring(key)))
+		},
+		table.Entry("options", OptionsKey, `"unterminated`),
+		table.Entry("size", MemoryLimitKey, "lots"),
+		table.Entry("negative size", CacheSizeKey, "-1Gi"),
+		table.Entry("mode", DirModeKey, "0999"),
+		table.Entry("too wide mode", FileModeKey, "01777"),
+		table.Entry("negative uid", UidKey, "-1"),
+		table.Entry("gid", GidKey, "wheel"),
+		table.Entry("timeout", MountTimeoutKey, "soon"),
+		table.Entry("zero timeout", UnmountTimeoutKey, "0s"),
+	)
+
+	It("rejects parameters which the mounter doesn't support", func() {
+		meta := &s3.FSMeta{Mounter: s3fsMounterType}
+		err := ParseParams(meta, nil, map[string]string{MemoryLimitKey: "1000", ReadAheadKey: "1Mi", DirModeKey: "0777"})
+		Expect(err).To(MatchError("mounter s3fs does not support parameters: memoryLimit, readAhead"))
+	})
+
+	It("accepts modes for s3fs", func() {
+		meta := &s3.FSMeta{Mounter: s3fsMounterType}
+		Expect(ParseParams(meta, nil, map[string]string{DirModeKey: "0755", FileModeKey: "0644"})).To(Succeed())
+		Expect(meta.DirMode).To(Equal(os.FileMode(0755)))
+		Expect(meta.FileMode).To(Equal(os.FileMode(0644)))
+	})
+
+	It("selects the mounter from the config", func() {
+		params := map[string]string{MemoryLimitKey: "1000"}
+		Expect(ParseParams(&s3.FSMeta{}, &s3.Config{Mounter: s3fsMounterType}, params)).NotTo(Succeed())
+		Expect(ParseParams(&s3.FSMeta{Mounter: geesefsMounterType}, &s3.Config{Mounter: s3fsMounterType}, params)).To(Succeed())
+		Expect(ValidateParams(params, &s3.Config{Mounter: s3fsMounterType})).NotTo(Succeed())
+		Expect(ValidateParams(params, &s3.Config{})).To(Succeed())
+	})
+
+	It("rejects unknown mounters", func() {
+		Expect(ValidateParams(map[string]string{TypeKey: "unknown"}, &s3.Config{})).To(MatchError(`unknown mounter "unknown"`))
+	})
+})
+
+var _ = Describe("ApplyMountGroup", func() {
+	It("grants access to the group", func() {
+		meta := &s3.FSMeta{}
+		Expect(ApplyMountGroup(meta, "0")).To(Succeed())
+		Expect(meta.Gid).To(Equal(intPtr(0)))
+		Expect(meta.DirMode).To(Equal(os.FileMode(0775)))
+		Expect(meta.FileMode).To(Equal(os.FileMode(0664)))
+	})
+
+	It("keeps explicit permissions", func() {
+		meta := &s3.FSMeta{DirMode: 0700, FileMode: 0600}
+		Expect(ApplyMountGroup(meta, "1000")).To(Succeed())
+		Expect(meta.DirMode).To(Equal(os.FileMode(0700)))
+		Expect(meta.FileMode).To(Equal(os.FileMode(0600)))
+	})
+
+	It("rejects non-numeric groups", func() {
+		Expect(ApplyMountGroup(&s3.FSMeta{}, "users")).NotTo(Succeed())
+	})
+})
+
+var _ = Describe("parseSize", func() {
+	table.DescribeTable("parses sizes",
+		func(str string, unit, expected int64) {
+			size, err := parseSize(map[string]string{"size": str}, "size", unit)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(size).To(Equal(expected))
+		},
+		table.Entry("unset", "", int64(1<<20), int64(0)),
+		table.Entry("plain number in units", "1000", int64(1<<20), int64(1000<<20)),
+		table.Entry("plain number in bytes", "1000", int64(1), int64(1000)),
+		table.Entry("fraction in units", "1.5", int64(1<<20), int64(3<<19)),
+		table.Entry("fraction without integer part", ".5", int64(1<<10), int64(512)),
+		table.Entry("fraction of a byte", "0.3", int64(1<<10), int64(308)),
+		table.Entry("binary suffix", "512Mi", int64(1<<20), int64(512<<20)),
+		table.Entry("decimal suffix", "1G", int64(1<<20), int64(1000000000)),
+		table.Entry("fraction with suffix", "1.5Gi", int64(1<<20), int64(3<<29)),
+		table.Entry("zero", "0", int64(1<<20), int64(0)),
+	)
+
+	table.DescribeTable("rejects invalid sizes",
+		func(str string, unit int64) {
+			_, err := parseSize(map[string]string{"size": str}, "size", unit)
+			Expect(err).To(MatchError(ContainSubstring(`invalid size "` + str + `"`)))
+		},
+		table.Entry("text", "lots", int64(1)),
+		table.Entry("negative", "-1Gi", int64(1)),
+		table.Entry("negative plain number", "-1", int64(1<<20)),
+		table.Entry("out of range", "9223372036854775807", int64(1<<20)),
+		table.Entry("fraction out of range", "9223372036854775.5", int64(1<<20)),
+	)
+})
+
+var _ = Describe("ceilDiv", func() {
+	table.DescribeTable("rounds up",
+		func(size, unit, expected int64) {
+			Expect(ceilDiv(size, unit)).To(Equal(expected))
+		},
+		table.Entry("zero", int64(0), int64(1<<20), int64(0)),
+		table.Entry("exact", int64(2<<20), int64(1<<20), int64(2)),
+		table.Entry("one byte more", int64(2<<20+1), int64(1<<20), int64(3)),
+		table.Entry("less than a unit", int64(1), int64(1<<20), int64(1)),
+		table.Entry("unit of a byte", int64(12345), int64(1), int64(12345)),
+	)
+})
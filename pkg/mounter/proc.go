@@ -0,0 +1,216 @@
+package mounter
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const fuseDevice = "/dev/fuse"
+
+// procRoot is the mount point of procfs, it's replaced in tests
+var procRoot = "/proc"
+
+// mountInfo is a line of /proc/self/mountinfo
+type mountInfo struct {
+	ID         int
+	Major      int
+	Minor      int
+	MountPoint string
+	FSType     string
+	Source     string
+}
+
+// isFuse checks if the mount is served by a FUSE process
+func (mi *mountInfo) isFuse() bool {
+	return mi.FSType == "fuse" || strings.HasPrefix(mi.FSType, "fuse.")
+}
+
+// FuseConnection returns the FUSE connection ID of the mount at path which
+// is also the name of its directory in /sys/fs/fuse/connections, or -1 if
+// path is not a FUSE mount
+func FuseConnection(path string) (int, error) {
+	mi, err := findMount(path)
+	if err != nil {
+		return -1, err
+	}
+	if mi == nil || !mi.isFuse() {
+		return -1, nil
+	}
+	// FUSE mounts use anonymous devices with major number 0, and
+	// their minor number is the connection ID
+	return mi.Minor, nil
+}
+
+// findMount returns the topmost mount at exactly path, or nil
+func findMount(path string) (*mountInfo, error) {
+	mounts, err := readMountInfo(filepath.Join(procRoot, "self", "mountinfo"))
+	if err != nil {
+		return nil, err
+	}
+	path = filepath.Clean(path)
+	var res *mountInfo
+	for i := range mounts {
+		if mounts[i].MountPoint == path {
+			// Later mounts hide earlier ones at the same path
+			res = &mounts[i]
+		}
+	}
+	return res, nil
+}
+
+func readMountInfo(file string) ([]mountInfo, error) {
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var mounts []mountInfo
+	scanner := bufio.NewScanner(bytes.NewReader(buf))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		mi, err := parseMountInfoLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing %s: %v", file, err)
+		}
+		mounts = append(mounts, mi)
+	}
+	return mounts, scanner.Err()
+}
+
+// parseMountInfoLine parses a line like
+// 36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+func parseMountInfoLine(line string) (mountInfo, error) {
+	var mi mountInfo
+	fields := strings.Fields(line)
+	sep := -1
+	for i := 6; i < len(fields); i++ {
+		if fields[i] == "-" {
+			sep = i
+			break
+		}
+	}
+	if sep < 0 || sep+2 >= len(fields) {
+		return mi, fmt.Errorf("invalid mountinfo line %q", line)
+	}
+	var err error
+	if mi.ID, err = strconv.Atoi(fields[0]); err != nil {
+		return mi, fmt.Errorf("invalid mount ID in %q", line)
+	}
+	dev := strings.SplitN(fields[2], ":", 2)
+	if len(dev) != 2 {
+		return mi, fmt.Errorf("invalid device number in %q", line)
+	}
+	if mi.Major, err = strconv.Atoi(dev[0]); err != nil {
+		return mi, fmt.Errorf("invalid device number in %q", line)
+	}
+	if mi.Minor, err = strconv.Atoi(dev[1]); err != nil {
+		return mi, fmt.Errorf("invalid device number in %q", line)
+	}
+	mi.MountPoint = unescapeMountInfo(fields[4])
+	mi.FSType = fields[sep+1]
+	mi.Source = unescapeMountInfo(fields[sep+2])
+	return mi, nil
+}
+
+// unescapeMountInfo decodes octal escapes like \040 which the kernel
+// uses for spaces and other special characters in paths
+func unescapeMountInfo(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// findFuseProcess returns the PID of a process which has /dev/fuse open
+// and has path as one of its command line arguments, or 0. The kernel doesn't
+// tell which /dev/fuse descriptor serves which connection, so it's an error
+// if several processes match, like a stale one of a lazily unmounted mount.
+func findFuseProcess(path string) (int, error) {
+	entries, err := ioutil.ReadDir(procRoot)
+	if err != nil {
+		return 0, err
+	}
+	path = filepath.Clean(path)
+	var found []int
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil || !e.IsDir() {
+			continue
+		}
+		args, err := getCmdArgs(pid)
+		if err != nil {
+			// The process may have exited
+			continue
+		}
+		if hasArg(args, path) && hasFuseDevice(pid) {
+			found = append(found, pid)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return 0, nil
+	case 1:
+		return found[0], nil
+	}
+	return 0, fmt.Errorf("Several processes serve FUSE mount %s: %v", path, found)
+}
+
+func hasArg(args []string, path string) bool {
+	for _, arg := range args {
+		if arg != "" && filepath.Clean(arg) == path {
+			return true
+		}
+	}
+	return false
+}
+
+func hasFuseDevice(pid int) bool {
+	fdDir := filepath.Join(procRoot, strconv.Itoa(pid), "fd")
+	fds, err := ioutil.ReadDir(fdDir)
+	if err != nil {
+		return false
+	}
+	for _, fd := range fds {
+		link, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+		if err == nil && link == fuseDevice {
+			return true
+		}
+	}
+	return false
+}
+
+func getCmdArgs(pid int) ([]string, error) {
+	cmdLine, err := getCmdLine(pid)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(strings.TrimRight(cmdLine, "\x00"), "\x00"), nil
+}
+
+func getCmdLine(pid int) (string, error) {
+	cmdLineFile := filepath.Join(procRoot, strconv.Itoa(pid), "cmdline")
+	cmdLine, err := ioutil.ReadFile(cmdLineFile)
+	if err != nil {
+		return "", err
+	}
+	return string(cmdLine), nil
+}
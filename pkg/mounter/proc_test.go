@@ -0,0 +1,140 @@
+package mounter
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const testMountInfo = `22 28 0:20 / /proc rw,nosuid,nodev,noexec,relatime shared:12 - proc proc rw
+28 1 253:1 / / rw,relatime shared:1 - ext4 /dev/vda1 rw
+210 28 0:52 / /var/lib/kubelet/pv/vol/globalmount rw,nosuid,nodev,relatime shared:110 - fuse.geesefs testbucket rw,user_id=0,group_id=0,allow_other
+211 28 0:53 / /var/lib/kubelet/pv/vol/globalmount2 rw,nosuid,nodev,relatime shared:111 - fuse.geesefs testbucket:prefix rw,user_id=0,group_id=0,allow_other
+212 28 0:52 / /var/lib/kubelet/pods/pod/volumes/vol rw,nosuid,nodev,relatime shared:110 - fuse.geesefs testbucket rw,user_id=0,group_id=0,allow_other
+213 28 0:54 / /mnt/with\040space rw,relatime - fuse.rclone :s3:bucket rw,user_id=0,group_id=0
+214 28 253:1 /data /mnt/bind rw,relatime - ext4 /dev/vda1 rw
+215 28 0:55 / /mnt/bind rw,relatime - fuse.s3fs s3fs rw,user_id=0,group_id=0
+`
+
+// fakeProcess is a process in a fake /proc tree
+type fakeProcess struct {
+	pid  int
+	args []string
+	fuse bool
+}
+
+func makeFakeProc(root string, procs []fakeProcess) {
+	Expect(os.MkdirAll(filepath.Join(root, "self"), 0755)).To(Succeed())
+	Expect(ioutil.WriteFile(filepath.Join(root, "self", "mountinfo"), []byte(testMountInfo), 0644)).To(Succeed())
+	for _, p := range procs {
+		dir := filepath.Join(root, strconv.Itoa(p.pid))
+		Expect(os.MkdirAll(filepath.Join(dir, "fd"), 0755)).To(Succeed())
+		cmdLine := strings.Join(p.args, "\x00") + "\x00"
+		Expect(ioutil.WriteFile(filepath.Join(dir, "cmdline"), []byte(cmdLine), 0644)).To(Succeed())
+		Expect(os.Symlink("/dev/null", filepath.Join(dir, "fd", "0"))).To(Succeed())
+		if p.fuse {
+			Expect(os.Symlink(fuseDevice, filepath.Join(dir, "fd", "3"))).To(Succeed())
+		}
+	}
+}
+
+var _ = Describe("Process discovery", func() {
+	var origProcRoot string
+
+	BeforeEach(func() {
+		origProcRoot = procRoot
+		root, err := ioutil.TempDir("", "fake-proc")
+		Expect(err).NotTo(HaveOccurred())
+		procRoot = root
+		makeFakeProc(root, []fakeProcess{
+			{pid: 100, args: []string{"/usr/bin/geesefs", "-f", "testbucket:prefix", "/var/lib/kubelet/pv/vol/globalmount2"}, fuse: true},
+			{pid: 200, args: []string{"/usr/bin/geesefs", "-f", "testbucket", "/var/lib/kubelet/pv/vol/globalmount/"}, fuse: true},
+			{pid: 250, args: []string{"grep", "/var/lib/kubelet/pv/vol/globalmount"}},
+			{pid: 300, args: []string{"rclone", "mount", ":s3:bucket", "/mnt/with space"}, fuse: true},
+			{pid: 400, args: []string{"s3fs", "bucket", "/mnt/bind"}, fuse: true},
+		})
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(procRoot)
+		procRoot = origProcRoot
+	})
+
+	Describe("parseMountInfoLine", func() {
+		It("parses optional fields and escapes", func() {
+			mi, err := parseMountInfoLine(`213 28 0:54 / /mnt/with\040space rw,relatime shared:1 master:2 - fuse.rclone :s3:my\040bucket rw`)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mi).To(Equal(mountInfo{
+				ID:         213,
+				Major:      0,
+				Minor:      54,
+				MountPoint: "/mnt/with space",
+				FSType:     "fuse.rclone",
+				Source:     ":s3:my bucket",
+			}))
+		})
+
+		It("rejects lines without the separator", func() {
+			_, err := parseMountInfoLine(`213 28 0:54 / /mnt rw,relatime fuse.rclone :s3:bucket rw`)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("FuseConnection", func() {
+		It("returns the minor device number of FUSE mounts", func() {
+			Expect(FuseConnection("/var/lib/kubelet/pv/vol/globalmount")).To(Equal(52))
+			Expect(FuseConnection("/var/lib/kubelet/pv/vol/globalmount2/")).To(Equal(53))
+			Expect(FuseConnection("/mnt/with space")).To(Equal(54))
+		})
+
+		It("uses the topmost mount", func() {
+			Expect(FuseConnection("/mnt/bind")).To(Equal(55))
+		})
+
+		It("returns -1 for other mounts", func() {
+			Expect(FuseConnection("/")).To(Equal(-1))
+			Expect(FuseConnection("/var/lib/kubelet/pv")).To(Equal(-1))
+		})
+	})
+
+	Describe("FindFuseMountProcess", func() {
+		findPid := func(path string) int {
+			p, err := FindFuseMountProcess(path)
+			Expect(err).NotTo(HaveOccurred())
+			if p == nil {
+				return 0
+			}
+			return p.Pid
+		}
+
+		It("matches the mount path exactly", func() {
+			Expect(findPid("/var/lib/kubelet/pv/vol/globalmount")).To(Equal(200))
+			Expect(findPid("/var/lib/kubelet/pv/vol/globalmount2")).To(Equal(100))
+		})
+
+		It("handles escaped paths", func() {
+			Expect(findPid("/mnt/with space")).To(Equal(300))
+		})
+
+		It("ignores bind mounts of FUSE mounts", func() {
+			Expect(findPid("/var/lib/kubelet/pods/pod/volumes/vol")).To(Equal(0))
+		})
+
+		It("ignores paths which are not FUSE mounts", func() {
+			Expect(findPid("/var/lib/kubelet/pv/vol")).To(Equal(0))
+		})
+
+		It("fails if several processes serve the mount", func() {
+			makeFakeProc(procRoot, []fakeProcess{
+				{pid: 500, args: []string{"s3fs", "bucket", "/mnt/bind/"}, fuse: true},
+			})
+			_, err := FindFuseMountProcess("/mnt/bind")
+			Expect(err).To(MatchError("Several processes serve FUSE mount /mnt/bind: [400 500]"))
+		})
+	})
+})
@@ -1,9 +1,10 @@
 package mounter
 
 import (
+	"context"
 	"fmt"
-	"os"
 	"path"
+	"strings"
 
 	"github.com/yandex-cloud/k8s-csi-s3/pkg/s3"
 )
@@ -21,6 +22,10 @@ const (
 	rcloneCmd = "rclone"
 )
 
+func init() {
+	Register(rcloneMounterType, newRcloneMounter, ReadAheadKey, DirModeKey, FileModeKey, UidKey, GidKey, CacheSizeKey)
+}
+
 func newRcloneMounter(meta *s3.FSMeta, cfg *s3.Config) (Mounter, error) {
 	return &rcloneMounter{
 		meta:            meta,
@@ -31,25 +36,86 @@ func newRcloneMounter(meta *s3.FSMeta, cfg *s3.Config) (Mounter, error) {
 	}, nil
 }
 
-func (rclone *rcloneMounter) Mount(target, volumeID string) error {
+func (rclone *rcloneMounter) Mount(ctx context.Context, target, volumeID string) error {
 	args := []string{
 		"mount",
 		fmt.Sprintf(":s3:%s", path.Join(rclone.meta.BucketName, rclone.meta.Prefix)),
 		fmt.Sprintf("%s", target),
-		"--daemon",
 		"--s3-provider=AWS",
 		"--s3-env-auth=true",
 		fmt.Sprintf("--s3-endpoint=%s", rclone.url),
 		"--allow-other",
-		"--vfs-cache-mode=writes",
+	}
+	if rclone.meta.CacheDir != "" {
+		args = append(
+			args,
+			"--vfs-cache-mode=full",
+			fmt.Sprintf("--cache-dir=%s", rclone.meta.CacheDir),
+			fmt.Sprintf("--vfs-cache-max-size=%dK", ceilDiv(rclone.meta.CacheSize, 1<<10)),
+		)
+	} else {
+		args = append(args, "--vfs-cache-mode=writes")
 	}
 	if rclone.region != "" {
 		args = append(args, fmt.Sprintf("--s3-region=%s", rclone.region))
 	}
-	args = append(args, rclone.meta.MountOptions...)
+	if rclone.meta.ReadAhead > 0 {
+		args = append(args, fmt.Sprintf("--vfs-read-ahead=%dK", ceilDiv(rclone.meta.ReadAhead, 1<<10)))
+	}
+	if rclone.meta.DirMode != 0 {
+		args = append(args, fmt.Sprintf("--dir-perms=%#o", uint32(rclone.meta.DirMode)))
+	}
+	if rclone.meta.FileMode != 0 {
+		args = append(args, fmt.Sprintf("--file-perms=%#o", uint32(rclone.meta.FileMode)))
+	}
+	if rclone.meta.DirMode != 0 || rclone.meta.FileMode != 0 {
+		// rclone masks permissions with the process umask by default
+		args = append(args, "--umask=0")
+	}
+	if rclone.meta.Uid != nil {
+		args = append(args, fmt.Sprintf("--uid=%d", *rclone.meta.Uid))
+	}
+	if rclone.meta.Gid != nil {
+		args = append(args, fmt.Sprintf("--gid=%d", *rclone.meta.Gid))
+	}
+	if rclone.meta.ReadOnly {
+		args = append(args, "--read-only")
+	}
+	args = append(args, rcloneSafeOptions(rclone.meta.MountOptions)...)
 	envs := []string{
 		"AWS_ACCESS_KEY_ID=" + rclone.accessKeyID,
 		"AWS_SECRET_ACCESS_KEY=" + rclone.secretAccessKey,
 	}
-	return fuseMount(target, rcloneCmd, args, envs)
+	return fuseMount(ctx, target, volumeID, rcloneCmd, args, envs, mountTimeout(rclone.meta))
+}
+
+// rcloneSafeOptions removes options accessing the local FS,
+// the cache directory is managed by the driver
+func rcloneSafeOptions(opts []string) []string {
+	var res []string
+	for i := 0; i < len(opts); i++ {
+		if opts[i] == "--cache-dir" {
+			i++
+		} else if !strings.HasPrefix(opts[i], "--cache-dir=") {
+			res = append(res, opts[i])
+		}
+	}
+	return res
+}
+
+func (rclone *rcloneMounter) Unmount(ctx context.Context, target, volumeID string) error {
+	return FuseUnmount(ctx, target, unmountTimeout(rclone.meta))
+}
+
+func (rclone *rcloneMounter) Status(target, volumeID string) error {
+	return checkFuseMount(target)
+}
+
+func (rclone *rcloneMounter) Capabilities() Capabilities {
+	return Capabilities{
+		Renames:      true,
+		RandomWrites: true,
+		ReadOnly:     true,
+		Cache:        true,
+	}
 }
@@ -0,0 +1,50 @@
+package mounter
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/s3"
+)
+
+// Factory creates a mounter for a volume
+type Factory func(meta *s3.FSMeta, cfg *s3.Config) (Mounter, error)
+
+type registration struct {
+	factory Factory
+	params  []string
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]registration)
+)
+
+// Register makes a mounter type available by name. params are the typed
+// volume parameters which the mounter translates to its own flags.
+// It panics if the name is already registered.
+func Register(name string, factory Factory, params ...string) {
+	if err := register(name, factory, params); err != nil {
+		panic(err)
+	}
+}
+
+func register(name string, factory Factory, params []string) error {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if name == "" {
+		return fmt.Errorf("mounter name is empty")
+	}
+	if _, ok := registry[name]; ok {
+		return fmt.Errorf("mounter %q is already registered", name)
+	}
+	registry[name] = registration{factory: factory, params: params}
+	return nil
+}
+
+func lookup(name string) (registration, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	r, ok := registry[name]
+	return r, ok
+}
@@ -0,0 +1,46 @@
+package mounter
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/s3"
+)
+
+// unregister removes a mounter registered by a test
+func unregister(name string) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	delete(registry, name)
+}
+
+var _ = Describe("Register", func() {
+	factory := func(meta *s3.FSMeta, cfg *s3.Config) (Mounter, error) {
+		return newGoofysMounter(meta, cfg)
+	}
+
+	AfterEach(func() {
+		unregister("test-registry")
+	})
+
+	It("makes the mounter available with its parameters", func() {
+		Register("test-registry", factory, UidKey)
+		m, err := New(&s3.FSMeta{Mounter: "test-registry"}, &s3.Config{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(m.(*instrumentedMounter).name).To(Equal("test-registry"))
+
+		Expect(ParseParams(&s3.FSMeta{Mounter: "test-registry"}, nil, map[string]string{UidKey: "1000"})).To(Succeed())
+		Expect(ParseParams(&s3.FSMeta{Mounter: "test-registry"}, nil, map[string]string{GidKey: "1000"})).
+			To(MatchError("mounter test-registry does not support parameters: gid"))
+	})
+
+	It("panics on duplicate names", func() {
+		Register("test-registry", factory)
+		Expect(func() { Register("test-registry", factory) }).To(Panic())
+		Expect(func() { Register(geesefsMounterType, factory) }).To(Panic())
+	})
+
+	It("rejects empty names", func() {
+		Expect(register("", factory, nil)).To(MatchError("mounter name is empty"))
+	})
+})
@@ -1,8 +1,10 @@
 package mounter
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/yandex-cloud/k8s-csi-s3/pkg/s3"
 )
@@ -19,6 +21,10 @@ const (
 	s3fsCmd = "s3fs"
 )
 
+func init() {
+	Register(s3fsMounterType, newS3fsMounter, DirModeKey, FileModeKey, UidKey, GidKey, CacheSizeKey)
+}
+
 func newS3fsMounter(meta *s3.FSMeta, cfg *s3.Config) (Mounter, error) {
 	return &s3fsMounter{
 		meta:          meta,
@@ -28,13 +34,14 @@ func newS3fsMounter(meta *s3.FSMeta, cfg *s3.Config) (Mounter, error) {
 	}, nil
 }
 
-func (s3fs *s3fsMounter) Mount(target, volumeID string) error {
+func (s3fs *s3fsMounter) Mount(ctx context.Context, target, volumeID string) error {
 	if err := writes3fsPass(s3fs.pwFileContent); err != nil {
 		return err
 	}
 	args := []string{
 		fmt.Sprintf("%s:/%s", s3fs.meta.BucketName, s3fs.meta.Prefix),
 		target,
+		"-f",
 		"-o", "use_path_request_style",
 		"-o", fmt.Sprintf("url=%s", s3fs.url),
 		"-o", "allow_other",
@@ -43,8 +50,61 @@ func (s3fs *s3fsMounter) Mount(target, volumeID string) error {
 	if s3fs.region != "" {
 		args = append(args, "-o", fmt.Sprintf("endpoint=%s", s3fs.region))
 	}
-	args = append(args, s3fs.meta.MountOptions...)
-	return fuseMount(target, s3fsCmd, args, nil)
+	if s3fs.meta.Uid != nil {
+		args = append(args, "-o", fmt.Sprintf("uid=%d", *s3fs.meta.Uid))
+	}
+	if s3fs.meta.Gid != nil {
+		args = append(args, "-o", fmt.Sprintf("gid=%d", *s3fs.meta.Gid))
+	}
+	if s3fs.meta.ReadOnly {
+		args = append(args, "-o", "ro")
+	}
+	if s3fs.meta.DirMode != 0 || s3fs.meta.FileMode != 0 {
+		// s3fs only has a single umask for both files and directories
+		umask := 0777 &^ (s3fs.meta.DirMode | s3fs.meta.FileMode)
+		args = append(args, "-o", fmt.Sprintf("umask=%04o", uint32(umask)))
+	}
+	if s3fs.meta.CacheDir != "" {
+		args = append(args, "-o", fmt.Sprintf("use_cache=%s", s3fs.meta.CacheDir))
+	}
+	args = append(args, s3fsSafeOptions(s3fs.meta.MountOptions)...)
+	return fuseMount(ctx, target, volumeID, s3fsCmd, args, nil, mountTimeout(s3fs.meta))
+}
+
+// s3fsSafeOptions removes options accessing the local FS from "-o" lists,
+// the cache directory is managed by the driver
+func s3fsSafeOptions(opts []string) []string {
+	var res []string
+	for i := 0; i < len(opts); i++ {
+		opt := opts[i]
+		prefix := ""
+		if opt == "-o" && i+1 < len(opts) {
+			i++
+			res = append(res, opt)
+			opt = opts[i]
+		} else if strings.HasPrefix(opt, "-o") {
+			prefix = "-o"
+			opt = opt[2:]
+		} else {
+			res = append(res, opt)
+			continue
+		}
+		var kept []string
+		for _, o := range strings.Split(opt, ",") {
+			if o != "use_cache" && !strings.HasPrefix(o, "use_cache=") {
+				kept = append(kept, o)
+			}
+		}
+		if len(kept) == 0 {
+			if prefix == "" {
+				// drop the preceding "-o"
+				res = res[0 : len(res)-1]
+			}
+			continue
+		}
+		res = append(res, prefix+strings.Join(kept, ","))
+	}
+	return res
 }
 
 func writes3fsPass(pwFileContent string) error {
@@ -60,3 +120,22 @@ func writes3fsPass(pwFileContent string) error {
 	pwFile.Close()
 	return nil
 }
+
+func (s3fs *s3fsMounter) Unmount(ctx context.Context, target, volumeID string) error {
+	return FuseUnmount(ctx, target, unmountTimeout(s3fs.meta))
+}
+
+func (s3fs *s3fsMounter) Status(target, volumeID string) error {
+	return checkFuseMount(target)
+}
+
+func (s3fs *s3fsMounter) Capabilities() Capabilities {
+	return Capabilities{
+		Renames:      true,
+		Symlinks:     true,
+		Permissions:  true,
+		RandomWrites: true,
+		ReadOnly:     true,
+		Cache:        true,
+	}
+}
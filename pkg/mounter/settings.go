@@ -0,0 +1,52 @@
+package mounter
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Settings are node-wide mounter settings, they may be changed at runtime
+type Settings struct {
+	// Timeouts of volumes which don't set them in parameters
+	MountTimeout   time.Duration
+	UnmountTimeout time.Duration
+	// Path of the geesefs binary
+	GeesefsPath string
+	// Plugin directory on the host, mounted at /csi in the container.
+	// Binaries of mounters started as systemd units are copied there.
+	PluginDir string
+	// Prefix of names of systemd units, so that units of drivers with
+	// different names don't clash
+	UnitPrefix string
+}
+
+// DefaultSettings are used until Configure is called
+var DefaultSettings = Settings{
+	MountTimeout:   10 * time.Second,
+	UnmountTimeout: 20 * time.Second,
+	GeesefsPath:    "/usr/bin/" + geesefsCmd,
+	PluginDir:      "/var/lib/kubelet/plugins/ru.yandex.s3.csi",
+}
+
+var settings atomic.Value
+
+func init() {
+	settings.Store(DefaultSettings)
+}
+
+// Configure changes the settings, they apply to subsequent mounts and unmounts
+func Configure(s Settings) {
+	settings.Store(s)
+}
+
+func currentSettings() Settings {
+	return settings.Load().(Settings)
+}
+
+// binaryPath returns the path of the binary of a built-in mounter
+func binaryPath(command string) string {
+	if command == geesefsCmd {
+		return currentSettings().GeesefsPath
+	}
+	return "/usr/bin/" + command
+}
@@ -0,0 +1,260 @@
+package mounter
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"k8s.io/kubernetes/pkg/util/mount"
+
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/logging"
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/metrics"
+)
+
+const (
+	restartBackoffInitial = time.Second
+	restartBackoffMax     = 5 * time.Minute
+	// Backoff is reset if the process has been running for this long
+	restartBackoffReset = 10 * time.Minute
+)
+
+// supervisor runs FUSE processes of volumes mounted without systemd
+// as its children and restarts them with exponential backoff when they
+// exit unexpectedly
+type supervisor struct {
+	mu    sync.Mutex
+	procs map[string]*supervisedProcess
+}
+
+type supervisedProcess struct {
+	volumeID string
+	target   string
+	command  string
+	args     []string
+	envs     []string
+	timeout  time.Duration
+
+	cmd      *exec.Cmd
+	started  time.Time
+	restarts int
+	stopped  bool
+	done     chan struct{}
+}
+
+var fuseSupervisor = &supervisor{
+	procs: make(map[string]*supervisedProcess),
+}
+
+// RestartCounts returns the number of restarts of supervised FUSE processes by volume ID
+func RestartCounts() map[string]int {
+	return fuseSupervisor.restartCounts()
+}
+
+func (s *supervisor) restartCounts() map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make(map[string]int, len(s.procs))
+	for id, p := range s.procs {
+		res[id] = p.restarts
+	}
+	return res
+}
+
+// start launches the FUSE process for the volume and waits until it's mounted
+func (s *supervisor) start(ctx context.Context, volumeID, target, command string, args, envs []string, timeout time.Duration) error {
+	s.mu.Lock()
+	if prev := s.procs[volumeID]; prev != nil {
+		s.mu.Unlock()
+		if prev.target != target {
+			return fmt.Errorf(
+				"Fuse process for volume %v is already running, but for a different"+
+					" directory. We want %v, but it's in %v",
+				volumeID, target, prev.target,
+			)
+		}
+		// Already supervised, possibly restarting after a crash
+		return waitForMount(target, timeout)
+	}
+	p := &supervisedProcess{
+		volumeID: volumeID,
+		target:   target,
+		command:  command,
+		args:     args,
+		envs:     envs,
+		timeout:  timeout,
+	}
+	err := p.run(logging.FromContext(ctx))
+	if err == nil {
+		s.procs[volumeID] = p
+	}
+	cmd, done := p.cmd, p.done
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	go s.watch(p)
+	if err := waitForProcessMount(target, cmd, done, timeout); err != nil {
+		s.abandon(p)
+		return err
+	}
+	return nil
+}
+
+// abandon stops supervising a process which failed to mount the volume and
+// kills it, so that a retry of the mount starts a new one
+func (s *supervisor) abandon(p *supervisedProcess) {
+	s.mu.Lock()
+	p.stopped = true
+	if s.procs[p.volumeID] == p {
+		delete(s.procs, p.volumeID)
+	}
+	// The watcher may have restarted it already
+	proc, done := p.cmd.Process, p.done
+	s.mu.Unlock()
+	proc.Kill()
+	<-done
+}
+
+func (p *supervisedProcess) run(log logging.Logger) error {
+	cmd := exec.Command(p.command, p.args...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	// cmd.Environ() returns envs inherited from the current process
+	cmd.Env = append(cmd.Environ(), p.envs...)
+	log.V(3).Infof("Mounting fuse with command: %s and args: %s", p.command, logging.RedactArgs(p.args))
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("Error fuseMount command: %s\nargs: %s\nerror: %v", p.command, logging.RedactArgs(p.args), err)
+	}
+	done := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(done)
+	}()
+	p.cmd = cmd
+	p.done = done
+	p.started = time.Now()
+	return nil
+}
+
+// waitForProcessMount waits for the mountpoint to appear or for the process to exit
+func waitForProcessMount(target string, cmd *exec.Cmd, done <-chan struct{}, timeout time.Duration) error {
+	mounted := make(chan error, 1)
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		mounted <- waitForMountUntil(target, timeout, stop)
+	}()
+	select {
+	case err := <-mounted:
+		return err
+	case <-done:
+		if notMnt, err := mount.New("").IsLikelyNotMountPoint(target); err == nil && !notMnt {
+			// The process has daemonized itself
+			return nil
+		}
+		return fmt.Errorf("Error fuseMount command: %s\nargs: %s\nprocess exited: %v", cmd.Path, logging.RedactArgs(cmd.Args[1:]), cmd.ProcessState)
+	}
+}
+
+// watch restarts the process with exponential backoff if it exits without being stopped
+func (s *supervisor) watch(p *supervisedProcess) {
+	attempt := 0
+	s.mu.Lock()
+	cmd, done := p.cmd, p.done
+	s.mu.Unlock()
+	for {
+		<-done
+		s.mu.Lock()
+		if p.stopped {
+			s.mu.Unlock()
+			return
+		}
+		if p.cmd.ProcessState.Success() {
+			if notMnt, err := mount.New("").IsLikelyNotMountPoint(p.target); err == nil && !notMnt {
+				logging.Warningf("Fuse process for volume %s has daemonized itself and can't be supervised", p.volumeID)
+				delete(s.procs, p.volumeID)
+				s.mu.Unlock()
+				return
+			}
+		}
+		logging.Errorf("Fuse process %v for volume %s exited unexpectedly: %v",
+			p.cmd.Process.Pid, p.volumeID, p.cmd.ProcessState)
+		if time.Since(p.started) >= restartBackoffReset {
+			attempt = 0
+		}
+		s.mu.Unlock()
+
+		for {
+			delay := restartBackoffMax
+			if attempt < 16 && restartBackoffInitial<<uint(attempt) < restartBackoffMax {
+				delay = restartBackoffInitial << uint(attempt)
+			}
+			attempt++
+			time.Sleep(delay)
+
+			s.mu.Lock()
+			if p.stopped {
+				s.mu.Unlock()
+				return
+			}
+			p.restarts++
+			metrics.IncFuseRestarts(filepath.Base(p.command))
+			logging.Infof("Restarting fuse process for volume %s, restart #%v", p.volumeID, p.restarts)
+			// Clean up the dead mountpoint which returns "Transport endpoint is not connected"
+			if err := mount.New("").Unmount(p.target); err != nil {
+				logging.V(4).Infof("Error unmounting dead fuse mount %s: %v", p.target, err)
+			}
+			err := p.run(logging.With("volume_id", p.volumeID))
+			cmd, done = p.cmd, p.done
+			s.mu.Unlock()
+			if err == nil {
+				break
+			}
+			logging.Errorf("Error restarting fuse process for volume %s: %v", p.volumeID, err)
+		}
+		if err := waitForProcessMount(p.target, cmd, done, p.timeout); err != nil {
+			logging.Errorf("Error remounting volume %s at %s: %v", p.volumeID, p.target, err)
+		} else {
+			logging.Infof("Volume %s remounted at %s", p.volumeID, p.target)
+		}
+	}
+}
+
+// pid returns the PID of the running process serving target, or 0
+func (s *supervisor) pid(target string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	target = filepath.Clean(target)
+	for _, p := range s.procs {
+		if filepath.Clean(p.target) != target {
+			continue
+		}
+		select {
+		case <-p.done:
+			// Exited and not restarted yet
+			return 0
+		default:
+			return p.cmd.Process.Pid
+		}
+	}
+	return 0
+}
+
+// stop marks the process serving target as stopped so that it isn't restarted
+// after unmount, and returns it with a channel which is closed when it exits
+func (s *supervisor) stop(target string) (*os.Process, <-chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, p := range s.procs {
+		if p.target == target {
+			p.stopped = true
+			delete(s.procs, id)
+			return p.cmd.Process, p.done
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,113 @@
+package mounter
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/kubernetes/pkg/util/mount"
+)
+
+var _ = Describe("Supervisor", func() {
+	var s *supervisor
+	var dir, target, pidFile string
+
+	BeforeEach(func() {
+		s = &supervisor{procs: make(map[string]*supervisedProcess)}
+		var err error
+		dir, err = ioutil.TempDir("", "supervisor")
+		Expect(err).NotTo(HaveOccurred())
+		target = filepath.Join(dir, "target")
+		Expect(os.Mkdir(target, 0755)).To(Succeed())
+		pidFile = filepath.Join(dir, "pid")
+	})
+
+	AfterEach(func() {
+		for _, p := range s.procs {
+			s.abandon(p)
+		}
+		mount.New("").Unmount(target)
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	// script runs a shell command which records its PID and execs into a
+	// long running process
+	script := func(command string) []string {
+		return []string{"-c", "echo $$ > " + pidFile + "; " + command + " exec sleep 100"}
+	}
+
+	readPid := func() int {
+		buf, err := ioutil.ReadFile(pidFile)
+		Expect(err).NotTo(HaveOccurred())
+		pid, err := strconv.Atoi(strings.TrimSpace(string(buf)))
+		Expect(err).NotTo(HaveOccurred())
+		return pid
+	}
+
+	// startMounting starts a process which mounts a tmpfs at the target
+	// like a FUSE process would
+	startMounting := func() {
+		if err := exec.Command("mount", "-t", "tmpfs", "none", target).Run(); err != nil {
+			Skip("mounting is not permitted: " + err.Error())
+		}
+		Expect(mount.New("").Unmount(target)).To(Succeed())
+		Expect(s.start(context.Background(), "vol", target, "sh", script("mount -t tmpfs none "+target+" &&"), nil, 5*time.Second)).To(Succeed())
+	}
+
+	It("forgets a process which exited before mounting", func() {
+		err := s.start(context.Background(), "vol", target, "sh", []string{"-c", "exit 1"}, nil, 5*time.Second)
+		Expect(err).To(HaveOccurred())
+		Expect(s.procs).To(BeEmpty())
+		Expect(s.pid(target)).To(BeZero())
+	})
+
+	It("stops waiting for the mount when it is no longer needed", func() {
+		stop := make(chan struct{})
+		waited := make(chan error, 1)
+		go func() {
+			waited <- waitForMountUntil(target, time.Minute, stop)
+		}()
+		close(stop)
+		Eventually(waited, time.Second).Should(Receive(Equal(errMountWaitStopped)))
+	})
+
+	It("kills a process which didn't mount in time", func() {
+		err := s.start(context.Background(), "vol", target, "sh", script(""), nil, 200*time.Millisecond)
+		Expect(err).To(Equal(ErrMountTimeout))
+		Expect(s.procs).To(BeEmpty())
+		Expect(syscall.Kill(readPid(), 0)).To(Equal(syscall.ESRCH))
+	})
+
+	It("restarts a process which exited", func() {
+		startMounting()
+		pid := readPid()
+		Expect(s.pid(target)).To(Equal(pid))
+
+		Expect(syscall.Kill(pid, syscall.SIGKILL)).To(Succeed())
+		Eventually(s.restartCounts, 5*time.Second).Should(Equal(map[string]int{"vol": 1}))
+		Eventually(func() int { return s.pid(target) }, 5*time.Second).ShouldNot(Or(BeZero(), Equal(pid)))
+		Expect(waitForMount(target, 5*time.Second)).To(Succeed())
+	})
+
+	It("doesn't restart a stopped process", func() {
+		startMounting()
+		proc, done := s.stop(target)
+		Expect(proc).NotTo(BeNil())
+		Expect(s.procs).To(BeEmpty())
+		Expect(proc.Kill()).To(Succeed())
+		Eventually(done).Should(BeClosed())
+		Consistently(s.restartCounts, 2*time.Second).Should(BeEmpty())
+
+		proc, done = s.stop(target)
+		Expect(proc).To(BeNil())
+		Expect(done).To(BeNil())
+	})
+})
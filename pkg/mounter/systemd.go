@@ -0,0 +1,209 @@
+package mounter
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	systemd "github.com/coreos/go-systemd/v22/dbus"
+	dbus "github.com/godbus/dbus/v5"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/logging"
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/s3"
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/tracing"
+)
+
+// Commands of mounters which may be started as systemd units on the host
+var systemdCommands = map[string]string{
+	geesefsCmd:      geesefsMounterType,
+	mountpointS3Cmd: mountpointS3MounterType,
+}
+
+type execCmd struct {
+	Path             string
+	Args             []string
+	UncleanIsFailure bool
+}
+
+func systemdUnitName(command, volumeID string) string {
+	return currentSettings().UnitPrefix + command + "-" + systemd.PathBusEscape(volumeID) + ".service"
+}
+
+func copyBinary(from, to string) error {
+	st, err := os.Stat(from)
+	if err != nil {
+		return fmt.Errorf("Failed to stat %s: %v", from, err)
+	}
+	st2, err := os.Stat(to)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Failed to stat %s: %v", to, err)
+	}
+	if err != nil || st2.Size() != st.Size() || st2.ModTime() != st.ModTime() {
+		if err == nil {
+			// remove the file first to not hit "text file busy" errors
+			err = os.Remove(to)
+			if err != nil {
+				return fmt.Errorf("Error removing %s to update it: %v", to, err)
+			}
+		}
+		bin, err := os.ReadFile(from)
+		if err != nil {
+			return fmt.Errorf("Error copying %s to %s: %v", from, to, err)
+		}
+		err = os.WriteFile(to, bin, 0755)
+		if err != nil {
+			return fmt.Errorf("Error copying %s to %s: %v", from, to, err)
+		}
+		err = os.Chtimes(to, st.ModTime(), st.ModTime())
+		if err != nil {
+			return fmt.Errorf("Error copying %s to %s: %v", from, to, err)
+		}
+	}
+	return nil
+}
+
+// systemdMount starts command as a transient systemd unit on the host so that
+// it doesn't get killed when the container exits. The binary is copied to the
+// plugin directory which is shared with the host. target must be the last argument.
+func systemdMount(ctx context.Context, conn *systemd.Conn, command, description, volumeID, target string, args, envs []string, meta *s3.FSMeta) error {
+	if err := copyBinary(binaryPath(command), "/csi/"+command); err != nil {
+		return err
+	}
+	args = append([]string{currentSettings().PluginDir + "/" + command}, args...)
+	logging.FromContext(ctx).Info("Starting " + command + " using systemd: " + strings.Join(logging.RedactArgs(args), " "))
+	unitName := systemdUnitName(command, volumeID)
+	newProps := []systemd.Property{
+		systemd.Property{
+			Name:  "Description",
+			Value: dbus.MakeVariant(description + " mount for Kubernetes volume " + volumeID),
+		},
+		systemd.PropExecStart(args, false),
+		systemd.Property{
+			Name: "ExecStopPost",
+			// force & lazy unmount to cleanup possibly dead mountpoints
+			Value: dbus.MakeVariant([]execCmd{execCmd{"/bin/umount", []string{"/bin/umount", "-f", "-l", target}, false}}),
+		},
+		systemd.Property{
+			Name:  "Environment",
+			Value: dbus.MakeVariant(envs),
+		},
+		systemd.Property{
+			Name:  "CollectMode",
+			Value: dbus.MakeVariant("inactive-or-failed"),
+		},
+		systemd.Property{
+			// systemd sends SIGKILL if the process doesn't exit after SIGTERM in this time
+			Name:  "TimeoutStopUSec",
+			Value: dbus.MakeVariant(uint64(unmountTimeout(meta) / time.Microsecond)),
+		},
+	}
+	unitProps, err := conn.GetAllProperties(unitName)
+	if err == nil {
+		// Unit already exists
+		if s, ok := unitProps["ActiveState"].(string); ok && (s == "active" || s == "activating" || s == "reloading") {
+			// Unit is already active
+			curPath := systemdUnitTarget(unitProps)
+			if curPath != target {
+				return fmt.Errorf(
+					"%s for volume %v is already mounted on host, but"+
+						" in a different directory. We want %v, but it's in %v",
+					description, volumeID, target, curPath,
+				)
+			}
+			// Already mounted at right location
+			return nil
+		} else {
+			// Stop and garbage collect the unit if automatic collection didn't work for some reason
+			conn.StopUnit(unitName, "replace", nil)
+			conn.ResetFailedUnit(unitName)
+		}
+	}
+	_, span := tracing.Start(ctx, "Start systemd unit", attribute.String("systemd.unit", unitName))
+	_, err = conn.StartTransientUnit(unitName, "replace", newProps, nil)
+	if err != nil {
+		err = fmt.Errorf("Error starting systemd unit %s on host: %v", unitName, err)
+	} else {
+		err = waitForMount(target, mountTimeout(meta))
+	}
+	tracing.End(span, err)
+	return err
+}
+
+// systemdUnitTarget returns the mountpoint of a FUSE systemd unit,
+// which is the last argument of its command line
+func systemdUnitTarget(unitProps map[string]interface{}) string {
+	prevExec, ok := unitProps["ExecStart"].([][]interface{})
+	if ok && len(prevExec) > 0 && len(prevExec[0]) >= 2 {
+		execArgs, ok := prevExec[0][1].([]string)
+		if ok && len(execArgs) >= 2 {
+			return execArgs[len(execArgs)-1]
+		}
+	}
+	return ""
+}
+
+// systemdUnitStopTimeout returns TimeoutStopUSec of a unit, which
+// systemdMount sets to the unmount timeout of the volume, or 0 if it's unset
+func systemdUnitStopTimeout(unitProps map[string]interface{}) time.Duration {
+	usec, ok := unitProps["TimeoutStopUSec"].(uint64)
+	if !ok || usec == 0 || usec > uint64(math.MaxInt64/time.Microsecond) {
+		// infinity is MaxUint64
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// systemdUnmount stops the systemd unit of command serving the volume,
+// it returns false if there is no active unit
+func systemdUnmount(ctx context.Context, command, volumeID string, timeout time.Duration) (bool, error) {
+	conn, err := systemd.New()
+	if err != nil {
+		// No systemd, so no systemd units
+		return false, nil
+	}
+	defer conn.Close()
+	log := logging.FromContext(ctx)
+	unitName := systemdUnitName(command, volumeID)
+	units, err := conn.ListUnitsByNames([]string{unitName})
+	if err != nil {
+		log.Errorf("Failed to list systemd unit by name %v: %v", unitName, err)
+		return false, err
+	}
+	if len(units) == 0 || units[0].ActiveState == "inactive" || units[0].ActiveState == "failed" {
+		return false, nil
+	}
+	log.Infof("Stopping systemd unit %s", unitName)
+	_, span := tracing.Start(ctx, "Stop systemd unit", attribute.String("systemd.unit", unitName))
+	result := make(chan string, 1)
+	if _, err = conn.StopUnit(unitName, "replace", result); err != nil {
+		tracing.End(span, err)
+		return true, err
+	}
+	select {
+	case res := <-result:
+		if res != "done" {
+			log.Warningf("Stopping systemd unit %s finished with result %s", unitName, res)
+		}
+	case <-time.After(timeout + escalationWait):
+		// The unit may have been started with a longer stop timeout
+		log.Warningf("Timeout stopping systemd unit %s, sending SIGKILL", unitName)
+		conn.KillUnit(unitName, int32(syscall.SIGKILL))
+	}
+	span.End()
+	return true, nil
+}
+
+// systemdOrFuseUnmount unmounts volumes of mounters which may run either
+// as systemd units or inside the container
+func systemdOrFuseUnmount(ctx context.Context, command, target, volumeID string, timeout time.Duration) error {
+	stopped, err := systemdUnmount(ctx, command, volumeID, timeout)
+	if stopped || err != nil {
+		return err
+	}
+	return FuseUnmount(ctx, target, timeout)
+}
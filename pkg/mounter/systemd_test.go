@@ -0,0 +1,40 @@
+package mounter
+
+import (
+	"math"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("systemdUnitStopTimeout", func() {
+	table.DescribeTable("reads the stop timeout of a unit",
+		func(props map[string]interface{}, expected time.Duration) {
+			Expect(systemdUnitStopTimeout(props)).To(Equal(expected))
+		},
+		table.Entry("set", map[string]interface{}{"TimeoutStopUSec": uint64(90000000)}, 90*time.Second),
+		table.Entry("unset", map[string]interface{}{}, time.Duration(0)),
+		table.Entry("zero", map[string]interface{}{"TimeoutStopUSec": uint64(0)}, time.Duration(0)),
+		table.Entry("infinity", map[string]interface{}{"TimeoutStopUSec": uint64(math.MaxUint64)}, time.Duration(0)),
+		table.Entry("wrong type", map[string]interface{}{"TimeoutStopUSec": "90s"}, time.Duration(0)),
+	)
+})
+
+var _ = Describe("systemdUnitName", func() {
+	AfterEach(func() {
+		Configure(DefaultSettings)
+	})
+
+	It("escapes the volume ID", func() {
+		Expect(systemdUnitName(geesefsCmd, "bucket/pvc-1")).To(Equal("geesefs-bucket_2fpvc_2d1.service"))
+	})
+
+	It("prefixes units of drivers with other names", func() {
+		settings := DefaultSettings
+		settings.UnitPrefix = "s3.csi.example.com-"
+		Configure(settings)
+		Expect(systemdUnitName(geesefsCmd, "bucket")).To(Equal("s3.csi.example.com-geesefs-bucket.service"))
+	})
+})
@@ -0,0 +1,193 @@
+package mounter
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"k8s.io/kubernetes/pkg/util/mount"
+
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/logging"
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/tracing"
+)
+
+const (
+	// Time to wait for the FUSE process after each escalation step
+	escalationWait = 5 * time.Second
+)
+
+// fusectlRoot is the mount point of the FUSE control filesystem
+var fusectlRoot = "/sys/fs/fuse/connections"
+
+// fuseUnmounter stops the FUSE process serving a mount, escalating
+// step by step if it doesn't exit in time
+type fuseUnmounter struct {
+	path    string
+	process *os.Process
+	// Closed when a process started by the driver exits
+	exited <-chan struct{}
+	// FUSE connection ID or -1
+	conn int
+	log  logging.Logger
+}
+
+// FuseUnmount unmounts path and waits up to timeout for its FUSE process to
+// exit. If it doesn't, the process is terminated with SIGTERM, the mount is
+// detached with a lazy unmount, the process is killed with SIGKILL and
+// finally the FUSE connection is aborted through fusectl.
+func FuseUnmount(ctx context.Context, path string, timeout time.Duration) (err error) {
+	if timeout <= 0 {
+		timeout = currentSettings().UnmountTimeout
+	}
+	_, span := tracing.Start(ctx, "Stop FUSE process", attribute.String("mount.target", path))
+	defer func() { tracing.End(span, err) }()
+	log := logging.FromContext(ctx)
+	u := &fuseUnmounter{path: path, conn: -1, log: log}
+	u.process, u.exited = fuseSupervisor.stop(path)
+	if u.process == nil {
+		// The mount disappears from mountinfo after unmounting,
+		// so the process must be found before it
+		p, err := FindFuseMountProcess(path)
+		if err != nil {
+			log.Errorf("Error getting PID of fuse mount: %s", err)
+		}
+		u.process = p
+	}
+	conn, err := FuseConnection(path)
+	if err != nil {
+		log.Errorf("Error getting FUSE connection of %s: %v", path, err)
+	}
+	u.conn = conn
+	return u.run(timeout)
+}
+
+func (u *fuseUnmounter) run(timeout time.Duration) error {
+	u.log.Infof("Unmounting %s", u.path)
+	err := mount.New("").Unmount(u.path)
+	if err != nil {
+		if u.process == nil && u.conn < 0 {
+			// Nothing is mounted and nothing runs
+			return err
+		}
+		u.log.Warningf("Error unmounting %s: %v", u.path, err)
+	}
+	if u.process == nil {
+		u.log.Warningf("Unable to find PID of fuse mount %s, it must have finished already", u.path)
+	} else {
+		u.log.Infof("Waiting up to %v for fuse process %v of %s to exit", timeout, u.process.Pid, u.path)
+	}
+	if u.wait(timeout) {
+		return nil
+	}
+
+	if u.process != nil {
+		u.log.Warningf("Fuse process %v of %s is still running, sending SIGTERM", u.process.Pid, u.path)
+		if err := u.process.Signal(syscall.SIGTERM); err != nil {
+			u.log.Warningf("Error sending SIGTERM to PID %v: %v", u.process.Pid, err)
+		}
+		if u.wait(escalationWait) {
+			return nil
+		}
+	}
+
+	if u.mounted() {
+		u.log.Warningf("%s is still mounted, detaching it with lazy unmount", u.path)
+		if err := syscall.Unmount(u.path, syscall.MNT_DETACH); err != nil {
+			u.log.Warningf("Error detaching %s: %v", u.path, err)
+		}
+	}
+
+	if u.process != nil && u.alive() {
+		u.log.Warningf("Fuse process %v of %s is still running, sending SIGKILL", u.process.Pid, u.path)
+		if err := u.process.Signal(syscall.SIGKILL); err != nil {
+			u.log.Warningf("Error sending SIGKILL to PID %v: %v", u.process.Pid, err)
+		}
+		if u.wait(escalationWait) {
+			return nil
+		}
+	}
+
+	if u.conn >= 0 {
+		u.log.Warningf("Aborting FUSE connection %v of %s", u.conn, u.path)
+		abort := filepath.Join(fusectlRoot, strconv.Itoa(u.conn), "abort")
+		if err := ioutil.WriteFile(abort, []byte("1"), 0200); err != nil && !os.IsNotExist(err) {
+			u.log.Warningf("Error aborting FUSE connection %v: %v", u.conn, err)
+		}
+		if u.wait(escalationWait) {
+			return nil
+		}
+	}
+
+	if u.process != nil && u.alive() {
+		return fmt.Errorf("Fuse process %v of %s didn't exit after SIGKILL", u.process.Pid, u.path)
+	}
+	return fmt.Errorf("Unable to unmount %s", u.path)
+}
+
+// wait waits until the process exits and the path is unmounted
+func (u *fuseUnmounter) wait(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if !u.alive() && !u.mounted() {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func (u *fuseUnmounter) alive() bool {
+	if u.exited != nil {
+		select {
+		case <-u.exited:
+			return false
+		default:
+			return true
+		}
+	}
+	if u.process == nil {
+		return false
+	}
+	cmdLine, err := getCmdLine(u.process.Pid)
+	if err != nil || cmdLine == "" {
+		// Zombies have an empty command line
+		return false
+	}
+	return u.process.Signal(syscall.Signal(0)) == nil
+}
+
+func (u *fuseUnmounter) mounted() bool {
+	mi, err := findMount(u.path)
+	if err != nil {
+		u.log.Warningf("Error reading mountinfo: %v", err)
+		return false
+	}
+	return mi != nil
+}
+
+// ForceUnmount unmounts a stale mountpoint whose FUSE process is dead
+// or hung. It does nothing if path is not mounted.
+func ForceUnmount(path string) error {
+	mi, err := findMount(path)
+	if err != nil || mi == nil {
+		return err
+	}
+	logging.Warningf("Force unmounting %s", path)
+	err = syscall.Unmount(path, syscall.MNT_FORCE)
+	if err == nil {
+		return nil
+	}
+	logging.Warningf("Error force unmounting %s: %v, detaching it with lazy unmount", path, err)
+	if err = syscall.Unmount(path, syscall.MNT_DETACH); err != nil {
+		return fmt.Errorf("Error detaching %s: %v", path, err)
+	}
+	return nil
+}
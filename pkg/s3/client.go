@@ -3,22 +3,40 @@ package s3
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
 
-	"github.com/golang/glog"
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
-)
+	"go.opentelemetry.io/otel/attribute"
 
-const (
-	metadataName = ".metadata.json"
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/logging"
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/metrics"
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/tracing"
 )
 
+// Number of objects removed in parallel when a bucket or a prefix is deleted one by one
+var deleteParallelism int32 = 16
+
+// Maximum number of objects in a multi-object delete request
+const removeBatchSize = 1000
+
+// SetDeleteParallelism changes the number of objects removed in parallel
+func SetDeleteParallelism(n int) {
+	atomic.StoreInt32(&deleteParallelism, int32(n))
+}
+
 type s3Client struct {
 	Config *Config
 	minio  *minio.Client
 	ctx    context.Context
+	// Progress of a deletion updated by RemoveBucket and RemovePrefix, or nil
+	deletion *deletionTracker
 }
 
 // Config holds values to configure the driver
@@ -31,11 +49,37 @@ type Config struct {
 }
 
 type FSMeta struct {
-	BucketName    string `json:"Name"`
-	Prefix        string `json:"Prefix"`
-	Mounter       string `json:"Mounter"`
+	BucketName    string   `json:"Name"`
+	Prefix        string   `json:"Prefix"`
+	Mounter       string   `json:"Mounter"`
 	MountOptions  []string `json:"MountOptions"`
-	CapacityBytes int64  `json:"CapacityBytes"`
+	CapacityBytes int64    `json:"CapacityBytes"`
+	// Typed mount options, zero values mean "mounter default"
+	MemoryLimit int64       `json:"MemoryLimit,omitempty"` // bytes
+	ReadAhead   int64       `json:"ReadAhead,omitempty"`   // bytes
+	DirMode     os.FileMode `json:"DirMode,omitempty"`
+	FileMode    os.FileMode `json:"FileMode,omitempty"`
+	// Owner of files, nil means mounter default
+	Uid       *int  `json:"Uid,omitempty"`
+	Gid       *int  `json:"Gid,omitempty"`
+	CacheSize int64 `json:"CacheSize,omitempty"` // bytes
+	// Disk cache directory prepared by the node, never taken from volume parameters
+	CacheDir string `json:"CacheDir,omitempty"`
+	// Mount the volume in read-only mode
+	ReadOnly bool `json:"ReadOnly,omitempty"`
+	// Zero values mean node defaults
+	MountTimeout   time.Duration `json:"MountTimeout,omitempty"`
+	UnmountTimeout time.Duration `json:"UnmountTimeout,omitempty"`
+}
+
+// ErrorCode returns the S3 error code of err like "AccessDenied",
+// or an empty string if err isn't an S3 error response
+func ErrorCode(err error) string {
+	var resp minio.ErrorResponse
+	if errors.As(err, &resp) {
+		return resp.Code
+	}
+	return ""
 }
 
 func NewClient(cfg *Config) (*s3Client, error) {
@@ -74,17 +118,66 @@ func NewClientFromSecret(secret map[string]string) (*s3Client, error) {
 	})
 }
 
+// WithContext returns a copy of the client which records S3 calls as
+// child spans of the span in ctx. Calls aren't cancelled with ctx.
+func (client *s3Client) WithContext(ctx context.Context) *s3Client {
+	c := *client
+	c.ctx = tracing.Detach(ctx)
+	return &c
+}
+
+// WithAbort returns a copy of the client which cancels calls when abort
+// is cancelled. RemoveBucket and RemovePrefix stop between objects then,
+// removed objects stay removed, so a retry continues the removal.
+func (client *s3Client) WithAbort(abort context.Context) *s3Client {
+	c := *client
+	c.ctx = tracing.DetachTo(abort, client.ctx)
+	return &c
+}
+
+// observe starts a span of an S3 call, the returned function
+// ends it and records metrics of the call
+func (client *s3Client) observe(operation, bucketName string) (context.Context, func(error)) {
+	start := time.Now()
+	ctx, span := tracing.Start(client.ctx, "S3 "+operation,
+		attribute.String("s3.operation", operation),
+		attribute.String("s3.bucket", bucketName),
+	)
+	return ctx, func(err error) {
+		metrics.ObserveS3(operation, start, err)
+		tracing.End(span, err)
+	}
+}
+
+// Ping checks that the S3 endpoint is reachable and accepts the credentials
+func (client *s3Client) Ping(timeout time.Duration) error {
+	ctx, done := client.observe("ListBuckets", "")
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	_, err := client.minio.ListBuckets(ctx)
+	done(err)
+	return err
+}
+
 func (client *s3Client) BucketExists(bucketName string) (bool, error) {
-	return client.minio.BucketExists(client.ctx, bucketName)
+	ctx, done := client.observe("BucketExists", bucketName)
+	exists, err := client.minio.BucketExists(ctx, bucketName)
+	done(err)
+	return exists, err
 }
 
 func (client *s3Client) CreateBucket(bucketName string) error {
-	return client.minio.MakeBucket(client.ctx, bucketName, minio.MakeBucketOptions{Region: client.Config.Region})
+	ctx, done := client.observe("MakeBucket", bucketName)
+	err := client.minio.MakeBucket(ctx, bucketName, minio.MakeBucketOptions{Region: client.Config.Region})
+	done(err)
+	return err
 }
 
 func (client *s3Client) CreatePrefix(bucketName string, prefix string) error {
 	if prefix != "" {
-		_, err := client.minio.PutObject(client.ctx, bucketName, prefix+"/", bytes.NewReader([]byte("")), 0, minio.PutObjectOptions{})
+		ctx, done := client.observe("PutObject", bucketName)
+		_, err := client.minio.PutObject(ctx, bucketName, prefix+"/", bytes.NewReader([]byte("")), 0, minio.PutObjectOptions{})
+		done(err)
 		if err != nil {
 			return err
 		}
@@ -96,13 +189,16 @@ func (client *s3Client) RemovePrefix(bucketName string, prefix string) error {
 	var err error
 
 	if err = client.removeObjects(bucketName, prefix); err == nil {
-		return client.minio.RemoveObject(client.ctx, bucketName, prefix, minio.RemoveObjectOptions{})
+		return client.removeObject(bucketName, prefix, minio.RemoveObjectOptions{})
+	}
+	if client.ctx.Err() != nil {
+		return err
 	}
 
-	glog.Warningf("removeObjects failed with: %s, will try removeObjectsOneByOne", err)
+	logging.Warningf("removeObjects failed with: %s, will try removeObjectsOneByOne", err)
 
 	if err = client.removeObjectsOneByOne(bucketName, prefix); err == nil {
-		return client.minio.RemoveObject(client.ctx, bucketName, prefix, minio.RemoveObjectOptions{})
+		return client.removeObject(bucketName, prefix, minio.RemoveObjectOptions{})
 	}
 
 	return err
@@ -112,15 +208,37 @@ func (client *s3Client) RemoveBucket(bucketName string) error {
 	var err error
 
 	if err = client.removeObjects(bucketName, ""); err == nil {
-		return client.minio.RemoveBucket(client.ctx, bucketName)
+		return client.removeBucket(bucketName)
+	}
+	if client.ctx.Err() != nil {
+		return err
 	}
 
-	glog.Warningf("removeObjects failed with: %s, will try removeObjectsOneByOne", err)
+	logging.Warningf("removeObjects failed with: %s, will try removeObjectsOneByOne", err)
 
 	if err = client.removeObjectsOneByOne(bucketName, ""); err == nil {
-		return client.minio.RemoveBucket(client.ctx, bucketName)
+		return client.removeBucket(bucketName)
+	}
+
+	return err
+}
+
+// removeBucket removes the metadata directory of a bucket, whose other
+// objects are removed already, and the empty bucket
+func (client *s3Client) removeBucket(bucketName string) error {
+	if err := client.untracked().removeObjects(bucketName, metadataDir); err != nil {
+		return err
 	}
+	ctx, done := client.observe("RemoveBucket", bucketName)
+	err := client.minio.RemoveBucket(ctx, bucketName)
+	done(err)
+	return err
+}
 
+func (client *s3Client) removeObject(bucketName, key string, opts minio.RemoveObjectOptions) error {
+	ctx, done := client.observe("RemoveObject", bucketName)
+	err := client.minio.RemoveObject(ctx, bucketName, key, opts)
+	done(err)
 	return err
 }
 
@@ -131,89 +249,164 @@ func (client *s3Client) removeObjects(bucketName, prefix string) error {
 	go func() {
 		defer close(objectsCh)
 
+		ctx, done := client.observe("ListObjects", bucketName)
 		for object := range client.minio.ListObjects(
-			client.ctx,
+			ctx,
 			bucketName,
 			minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
 			if object.Err != nil {
 				listErr = object.Err
+				done(listErr)
 				return
 			}
+			if prefix == "" && strings.HasPrefix(object.Key, metadataDir) {
+				// Removed last by RemoveBucket
+				continue
+			}
 			objectsCh <- object
 		}
+		done(nil)
 	}()
 
+	// Objects are removed in batches of one multi-object delete request,
+	// so that removed objects are counted as each batch completes
+	failed := 0
+	batch := make([]minio.ObjectInfo, 0, removeBatchSize)
+	for object := range objectsCh {
+		batch = append(batch, object)
+		if len(batch) == removeBatchSize {
+			failed += client.removeBatch(bucketName, batch)
+			batch = batch[:0]
+		}
+	}
+	if len(batch) > 0 {
+		failed += client.removeBatch(bucketName, batch)
+	}
+
+	// The listing is finished when objectsCh is closed
 	if listErr != nil {
-		glog.Error("Error listing objects", listErr)
+		logging.Error("Error listing objects", listErr)
 		return listErr
 	}
+	if failed > 0 {
+		return fmt.Errorf("Failed to remove all objects of bucket %s", bucketName)
+	}
+	return nil
+}
+
+// removeBatch removes objects with one multi-object delete request, counts
+// removed ones and returns the number of objects which weren't removed
+func (client *s3Client) removeBatch(bucketName string, batch []minio.ObjectInfo) int {
+	objectsCh := make(chan minio.ObjectInfo, len(batch))
+	for _, object := range batch {
+		objectsCh <- object
+	}
+	close(objectsCh)
 
-	select {
-	default:
-		opts := minio.RemoveObjectsOptions{
-			GovernanceBypass: true,
-		}
-		errorCh := client.minio.RemoveObjects(client.ctx, bucketName, objectsCh, opts)
-		haveErrWhenRemoveObjects := false
-		for e := range errorCh {
-			glog.Errorf("Failed to remove object %s, error: %s", e.ObjectName, e.Err)
-			haveErrWhenRemoveObjects = true
-		}
-		if haveErrWhenRemoveObjects {
-			return fmt.Errorf("Failed to remove all objects of bucket %s", bucketName)
+	opts := minio.RemoveObjectsOptions{
+		GovernanceBypass: true,
+	}
+	ctx, done := client.observe("RemoveObjects", bucketName)
+	failedKeys := make(map[string]bool)
+	requestFailed := false
+	for e := range client.minio.RemoveObjects(ctx, bucketName, objectsCh, opts) {
+		logging.Errorf("Failed to remove object %s, error: %s", e.ObjectName, e.Err)
+		if e.ObjectName == "" {
+			// The whole request failed
+			requestFailed = true
 		}
+		failedKeys[e.ObjectName] = true
+	}
+	if requestFailed {
+		err := fmt.Errorf("Failed to remove objects of bucket %s", bucketName)
+		done(err)
+		return len(batch)
 	}
 
-	return nil
+	var objects, bytes int64
+	for _, object := range batch {
+		if !failedKeys[object.Key] {
+			objects++
+			bytes += object.Size
+		}
+	}
+	if failed := len(batch) - int(objects); failed > 0 {
+		done(fmt.Errorf("Failed to remove %v objects of bucket %s", failed, bucketName))
+	} else {
+		done(nil)
+	}
+	metrics.AddDeletedBytes(bytes)
+	client.removed(objects, bytes)
+	return len(batch) - int(objects)
 }
 
 // will delete files one by one without file lock
 func (client *s3Client) removeObjectsOneByOne(bucketName, prefix string) error {
-	parallelism := 16
+	parallelism := int(atomic.LoadInt32(&deleteParallelism))
 	objectsCh := make(chan minio.ObjectInfo, 1)
 	guardCh := make(chan int, parallelism)
 	var listErr error
 	totalObjects := 0
-	removeErrors := 0
+	removeErrors := int64(0)
 
 	go func() {
 		defer close(objectsCh)
 
-		for object := range client.minio.ListObjects(client.ctx, bucketName,
+		ctx, done := client.observe("ListObjects", bucketName)
+		for object := range client.minio.ListObjects(ctx, bucketName,
 			minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
 			if object.Err != nil {
 				listErr = object.Err
+				done(listErr)
 				return
 			}
+			if prefix == "" && strings.HasPrefix(object.Key, metadataDir) {
+				// Removed last by RemoveBucket
+				continue
+			}
 			totalObjects++
 			objectsCh <- object
 		}
+		done(nil)
 	}()
 
-	if listErr != nil {
-		glog.Error("Error listing objects", listErr)
-		return listErr
-	}
-
+	removed := int64(0)
 	for object := range objectsCh {
+		if client.ctx.Err() != nil {
+			// Aborted, the listing stops as its context is cancelled
+			continue
+		}
+		object := object
 		guardCh <- 1
 		go func() {
-			err := client.minio.RemoveObject(client.ctx, bucketName, object.Key,
+			err := client.removeObject(bucketName, object.Key,
 				minio.RemoveObjectOptions{VersionID: object.VersionID})
 			if err != nil {
-				glog.Errorf("Failed to remove object %s, error: %s", object.Key, err)
-				removeErrors++
+				logging.Errorf("Failed to remove object %s, error: %s", object.Key, err)
+				atomic.AddInt64(&removeErrors, 1)
+			} else {
+				atomic.AddInt64(&removed, 1)
+				metrics.AddDeletedBytes(object.Size)
+				client.removed(1, object.Size)
 			}
-			<- guardCh
+			<-guardCh
 		}()
 	}
 	for i := 0; i < parallelism; i++ {
 		guardCh <- 1
 	}
 	for i := 0; i < parallelism; i++ {
-		<- guardCh
+		<-guardCh
 	}
 
+	if err := client.ctx.Err(); err != nil {
+		return fmt.Errorf("Removal of objects of %s/%s aborted after %v objects: %w", bucketName, prefix, removed, err)
+	}
+	// The listing is finished when objectsCh is closed
+	if listErr != nil {
+		logging.Error("Error listing objects", listErr)
+		return listErr
+	}
 	if removeErrors > 0 {
 		return fmt.Errorf("Failed to remove %v objects out of total %v of path %s", removeErrors, totalObjects, bucketName)
 	}
@@ -0,0 +1,94 @@
+package s3
+
+import (
+	"sync"
+	"time"
+
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/logging"
+)
+
+const deletionName = "deletion.json"
+
+// Interval of writing the progress of a deletion while objects are removed
+var deletionProgressInterval = 30 * time.Second
+
+// DeletionProgress is stored next to the metadata of a volume while it's
+// being deleted, so that a deletion interrupted by a shutdown of the
+// controller is continued by the retry instead of being started over
+type DeletionProgress struct {
+	Started        time.Time `json:"Started"`
+	Updated        time.Time `json:"Updated"`
+	RemovedObjects int64     `json:"RemovedObjects"`
+	RemovedBytes   int64     `json:"RemovedBytes"`
+}
+
+// deletionTracker counts objects removed by a client and writes the progress
+type deletionTracker struct {
+	mu         sync.Mutex
+	bucketName string
+	prefix     string
+	progress   *DeletionProgress
+	written    time.Time
+}
+
+// ReadDeletionProgress returns the progress of an interrupted deletion of a
+// volume, or nil if its deletion hasn't been started
+func (client *s3Client) ReadDeletionProgress(bucketName, prefix string) (*DeletionProgress, error) {
+	progress := &DeletionProgress{}
+	err := client.getJSON(bucketName, metadataRoot(prefix)+deletionName, progress)
+	if code := ErrorCode(err); code == "NoSuchKey" || code == "NoSuchBucket" {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return progress, nil
+}
+
+// WriteDeletionProgress writes the progress of a deletion of a volume
+func (client *s3Client) WriteDeletionProgress(bucketName, prefix string, progress *DeletionProgress) error {
+	progress.Updated = time.Now()
+	return client.putJSON(bucketName, metadataRoot(prefix)+deletionName, progress)
+}
+
+// WithDeletionProgress returns a copy of the client which adds objects removed
+// by RemoveBucket and RemovePrefix to progress and writes it periodically.
+// The metadata directory of a bucket is removed last, so the progress stays
+// until all other objects are removed.
+func (client *s3Client) WithDeletionProgress(bucketName, prefix string, progress *DeletionProgress) *s3Client {
+	c := *client
+	c.deletion = &deletionTracker{
+		bucketName: bucketName,
+		prefix:     prefix,
+		progress:   progress,
+		written:    time.Now(),
+	}
+	return &c
+}
+
+// removed records removed objects and writes the progress if it's time to
+func (client *s3Client) removed(objects, bytes int64) {
+	t := client.deletion
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.progress.RemovedObjects += objects
+	t.progress.RemovedBytes += bytes
+	if time.Since(t.written) < deletionProgressInterval {
+		return
+	}
+	t.written = time.Now()
+	if err := client.WriteDeletionProgress(t.bucketName, t.prefix, t.progress); err != nil {
+		logging.Warningf("Error writing deletion progress of %s/%s: %v", t.bucketName, t.prefix, err)
+	}
+}
+
+// untracked returns a copy of the client which doesn't update the progress
+// of a deletion, it's used to remove the progress itself
+func (client *s3Client) untracked() *s3Client {
+	c := *client
+	c.deletion = nil
+	return &c
+}
@@ -0,0 +1,121 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Deletion", func() {
+	const bucket = "test-deletion"
+	var client *s3Client
+	var origInterval time.Duration
+
+	BeforeEach(func() {
+		client = newTestClient()
+		newTestBucket(client, bucket)
+		origInterval = deletionProgressInterval
+		// Write the progress after every object
+		deletionProgressInterval = 0
+	})
+
+	AfterEach(func() {
+		deletionProgressInterval = origInterval
+		exists, err := client.BucketExists(bucket)
+		Expect(err).NotTo(HaveOccurred())
+		if exists {
+			Expect(client.RemoveBucket(bucket)).To(Succeed())
+		}
+	})
+
+	putObjects := func(prefix string, n int) {
+		for i := 0; i < n; i++ {
+			_, err := client.minio.PutObject(client.ctx, bucket, fmt.Sprintf("%s/file-%d", prefix, i),
+				bytes.NewReader([]byte("data")), 4, minio.PutObjectOptions{})
+			Expect(err).NotTo(HaveOccurred())
+		}
+	}
+
+	listKeys := func(prefix string) []string {
+		keys, err := client.listKeys(bucket, prefix)
+		Expect(err).NotTo(HaveOccurred())
+		return keys
+	}
+
+	It("writes and reads the progress", func() {
+		progress, err := client.ReadDeletionProgress(bucket, "pvc-1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(progress).To(BeNil())
+
+		started := time.Now().UTC().Truncate(time.Second)
+		Expect(client.WriteDeletionProgress(bucket, "pvc-1", &DeletionProgress{Started: started, RemovedObjects: 3})).To(Succeed())
+		progress, err = client.ReadDeletionProgress(bucket, "pvc-1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(progress.Started).To(Equal(started))
+		Expect(progress.RemovedObjects).To(Equal(int64(3)))
+
+		progress, err = client.ReadDeletionProgress("test-deletion-missing", "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(progress).To(BeNil())
+	})
+
+	It("counts and writes removed objects of a prefix", func() {
+		putObjects("pvc-1", 5)
+		progress := &DeletionProgress{RemovedObjects: 2}
+		Expect(client.WithDeletionProgress(bucket, "pvc-1", progress).RemovePrefix(bucket, "pvc-1")).To(Succeed())
+		Expect(progress.RemovedObjects).To(Equal(int64(7)))
+		Expect(progress.RemovedBytes).To(Equal(int64(20)))
+
+		written, err := client.ReadDeletionProgress(bucket, "pvc-1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(written.RemovedObjects).To(Equal(int64(7)))
+
+		Expect(client.WithDeletionProgress(bucket, "pvc-1", progress).RemoveVolumeMeta(bucket, "pvc-1")).To(Succeed())
+		Expect(listKeys("")).To(BeEmpty())
+	})
+
+	It("counts objects of several batches once", func() {
+		putObjects("pvc-1", removeBatchSize+1)
+		progress := &DeletionProgress{}
+		Expect(client.WithDeletionProgress(bucket, "pvc-1", progress).RemovePrefix(bucket, "pvc-1")).To(Succeed())
+		Expect(progress.RemovedObjects).To(Equal(int64(removeBatchSize + 1)))
+		Expect(progress.RemovedBytes).To(Equal(int64(4 * (removeBatchSize + 1))))
+		Expect(listKeys("pvc-1")).To(BeEmpty())
+	})
+
+	It("counts objects removed one by one", func() {
+		putObjects("pvc-1", 5)
+		progress := &DeletionProgress{}
+		Expect(client.WithDeletionProgress(bucket, "pvc-1", progress).removeObjectsOneByOne(bucket, "pvc-1")).To(Succeed())
+		Expect(progress.RemovedObjects).To(Equal(int64(5)))
+		Expect(listKeys("pvc-1")).To(BeEmpty())
+	})
+
+	It("removes the metadata of a bucket last", func() {
+		putObjects("data", 3)
+		Expect(client.WriteVolumeMeta(bucket, "", &VolumeMeta{VolumeID: bucket})).To(Succeed())
+		progress := &DeletionProgress{}
+		Expect(client.WriteDeletionProgress(bucket, "", progress)).To(Succeed())
+
+		Expect(client.WithDeletionProgress(bucket, "", progress).RemoveBucket(bucket)).To(Succeed())
+		Expect(progress.RemovedObjects).To(Equal(int64(3)))
+		exists, err := client.BucketExists(bucket)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(exists).To(BeFalse())
+	})
+
+	It("stops removing objects one by one when aborted", func() {
+		putObjects("pvc-1", 5)
+		abort, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := client.WithAbort(abort).removeObjectsOneByOne(bucket, "pvc-1")
+		Expect(errors.Is(err, context.Canceled)).To(BeTrue())
+		Expect(listKeys("pvc-1")).To(HaveLen(5))
+	})
+})
@@ -0,0 +1,248 @@
+package s3
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/logging"
+)
+
+// Volumes created by the driver have a metadata object, nodes which have
+// staged a volume keep their status next to it. They are stored in a hidden
+// directory in the root of the bucket, which is outside of the mounted path
+// for volumes with a prefix.
+const (
+	metadataDir    = ".csi-s3/"
+	metadataName   = "metadata.json"
+	nodeStatusDir  = "nodes/"
+	prefixesDir    = metadataDir + "prefixes/"
+	bucketMetadata = metadataDir + "bucket/"
+)
+
+// VolumeMeta describes a volume created by the driver
+type VolumeMeta struct {
+	// Name of the driver which created the volume
+	Driver        string            `json:"Driver"`
+	VolumeID      string            `json:"VolumeID"`
+	CapacityBytes int64             `json:"CapacityBytes"`
+	VolumeContext map[string]string `json:"VolumeContext,omitempty"`
+}
+
+// NodeStatus is the last known status of a volume on a node which has staged it
+type NodeStatus struct {
+	NodeID   string    `json:"NodeID"`
+	Abnormal bool      `json:"Abnormal"`
+	Message  string    `json:"Message"`
+	Time     time.Time `json:"Time"`
+}
+
+// metadataRoot returns the directory with metadata of a volume
+func metadataRoot(prefix string) string {
+	if prefix == "" {
+		return bucketMetadata
+	}
+	return prefixesDir + prefix + "/"
+}
+
+func nodeStatusKey(prefix, nodeID string) string {
+	return metadataRoot(prefix) + nodeStatusDir + nodeID + ".json"
+}
+
+// WriteVolumeMeta writes the metadata object of a volume
+func (client *s3Client) WriteVolumeMeta(bucketName, prefix string, meta *VolumeMeta) error {
+	return client.putJSON(bucketName, metadataRoot(prefix)+metadataName, meta)
+}
+
+// RemoveVolumeMeta removes the metadata of a volume with a prefix,
+// metadata of bucket volumes is removed with the bucket
+func (client *s3Client) RemoveVolumeMeta(bucketName, prefix string) error {
+	return client.untracked().removeObjects(bucketName, metadataRoot(prefix))
+}
+
+// WriteNodeStatus writes the status of a volume on a node
+func (client *s3Client) WriteNodeStatus(bucketName, prefix string, status *NodeStatus) error {
+	return client.putJSON(bucketName, nodeStatusKey(prefix, status.NodeID), status)
+}
+
+// RemoveNodeStatus removes the status of a volume on a node
+func (client *s3Client) RemoveNodeStatus(bucketName, prefix, nodeID string) error {
+	return client.removeObject(bucketName, nodeStatusKey(prefix, nodeID), minio.RemoveObjectOptions{})
+}
+
+// VolumeInfo is a volume found by ListVolumes
+type VolumeInfo struct {
+	BucketName string
+	Prefix     string
+	Meta       *VolumeMeta
+	Nodes      []NodeStatus
+}
+
+// ReadVolumeMeta reads the metadata object of a volume and statuses of nodes
+// which have staged it. The metadata is nil if the volume doesn't have it.
+func (client *s3Client) ReadVolumeMeta(bucketName, prefix string) (*VolumeMeta, []NodeStatus, error) {
+	keys, err := client.listKeys(bucketName, metadataRoot(prefix))
+	if err != nil {
+		return nil, nil, err
+	}
+	return client.readVolume(bucketName, prefix, keys)
+}
+
+// ListVolumes returns volumes created by driver ordered by bucket and prefix,
+// starting after the volume afterBucket/afterPrefix if afterBucket isn't
+// empty. At most limit volumes are returned if limit is positive, more is
+// true then if there may be further volumes. Buckets which can't be read are
+// skipped.
+func (client *s3Client) ListVolumes(driver, afterBucket, afterPrefix string, limit int) (volumes []VolumeInfo, more bool, err error) {
+	ctx, done := client.observe("ListBuckets", "")
+	buckets, err := client.minio.ListBuckets(ctx)
+	done(err)
+	if err != nil {
+		return nil, false, err
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].Name < buckets[j].Name })
+	for _, bucket := range buckets {
+		if bucket.Name < afterBucket {
+			continue
+		}
+		keys, err := client.listKeys(bucket.Name, metadataDir)
+		if err != nil {
+			logging.Warningf("Error listing bucket %s, skipping it: %v", bucket.Name, err)
+			continue
+		}
+		byPrefix := groupMetadataKeys(keys)
+		var prefixes []string
+		for prefix := range byPrefix {
+			if bucket.Name == afterBucket && prefix <= afterPrefix {
+				continue
+			}
+			prefixes = append(prefixes, prefix)
+		}
+		sort.Strings(prefixes)
+		for _, prefix := range prefixes {
+			if limit > 0 && len(volumes) >= limit {
+				return volumes, true, nil
+			}
+			meta, nodes, err := client.readVolume(bucket.Name, prefix, byPrefix[prefix])
+			if err != nil {
+				return nil, false, err
+			}
+			// Skip prefixes which only have node statuses and volumes of other drivers
+			if meta == nil || meta.Driver != driver {
+				continue
+			}
+			volumes = append(volumes, VolumeInfo{BucketName: bucket.Name, Prefix: prefix, Meta: meta, Nodes: nodes})
+		}
+	}
+	return volumes, false, nil
+}
+
+// groupMetadataKeys groups keys in the metadata directory of a bucket by
+// prefixes of volumes they belong to
+func groupMetadataKeys(keys []string) map[string][]string {
+	byPrefix := make(map[string][]string)
+	for _, key := range keys {
+		if strings.HasPrefix(key, bucketMetadata) {
+			byPrefix[""] = append(byPrefix[""], key)
+			continue
+		}
+		if !strings.HasPrefix(key, prefixesDir) {
+			continue
+		}
+		rest := strings.TrimPrefix(key, prefixesDir)
+		if strings.HasSuffix(rest, "/"+metadataName) {
+			prefix := strings.TrimSuffix(rest, "/"+metadataName)
+			byPrefix[prefix] = append(byPrefix[prefix], key)
+		} else if i := strings.LastIndex(rest, "/"+nodeStatusDir); i > 0 {
+			byPrefix[rest[:i]] = append(byPrefix[rest[:i]], key)
+		}
+	}
+	return byPrefix
+}
+
+// readVolume reads the metadata and node statuses of a volume from listed keys
+func (client *s3Client) readVolume(bucketName, prefix string, keys []string) (*VolumeMeta, []NodeStatus, error) {
+	root := metadataRoot(prefix)
+	metaKey := root + metadataName
+	var meta *VolumeMeta
+	var nodes []NodeStatus
+	for _, key := range keys {
+		switch {
+		case key == metaKey:
+			meta = &VolumeMeta{}
+			if err := client.getJSON(bucketName, key, meta); err != nil {
+				if ErrorCode(err) == "NoSuchKey" {
+					// The volume has been deleted after listing
+					return nil, nil, nil
+				}
+				return nil, nil, err
+			}
+		case strings.HasPrefix(key, root+nodeStatusDir) && strings.HasSuffix(key, ".json"):
+			var status NodeStatus
+			if err := client.getJSON(bucketName, key, &status); err != nil {
+				if ErrorCode(err) == "NoSuchKey" {
+					// The node has unstaged the volume after listing
+					continue
+				}
+				return nil, nil, err
+			}
+			nodes = append(nodes, status)
+		}
+	}
+	if meta == nil {
+		return nil, nil, nil
+	}
+	return meta, nodes, nil
+}
+
+// listKeys returns keys of all objects under a prefix
+func (client *s3Client) listKeys(bucketName, prefix string) ([]string, error) {
+	var keys []string
+	ctx, done := client.observe("ListObjects", bucketName)
+	for object := range client.minio.ListObjects(ctx, bucketName,
+		minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if object.Err != nil {
+			done(object.Err)
+			return nil, object.Err
+		}
+		keys = append(keys, object.Key)
+	}
+	done(nil)
+	return keys, nil
+}
+
+func (client *s3Client) putJSON(bucketName, key string, v interface{}) error {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	ctx, done := client.observe("PutObject", bucketName)
+	_, err = client.minio.PutObject(ctx, bucketName, key, bytes.NewReader(buf), int64(len(buf)),
+		minio.PutObjectOptions{ContentType: "application/json"})
+	done(err)
+	return err
+}
+
+func (client *s3Client) getJSON(bucketName, key string, v interface{}) error {
+	ctx, done := client.observe("GetObject", bucketName)
+	object, err := client.minio.GetObject(ctx, bucketName, key, minio.GetObjectOptions{})
+	if err == nil {
+		var buf []byte
+		buf, err = ioutil.ReadAll(object)
+		object.Close()
+		if err == nil {
+			err = json.Unmarshal(buf, v)
+			if err != nil {
+				err = fmt.Errorf("Error parsing %s/%s: %v", bucketName, key, err)
+			}
+		}
+	}
+	done(err)
+	return err
+}
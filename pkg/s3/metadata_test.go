@@ -0,0 +1,170 @@
+package s3
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Volume metadata", func() {
+	const bucket = "test-metadata"
+	var client *s3Client
+
+	BeforeEach(func() {
+		client = newTestClient()
+		newTestBucket(client, bucket)
+	})
+
+	AfterEach(func() {
+		Expect(client.RemoveBucket(bucket)).To(Succeed())
+	})
+
+	listKeys := func(prefix string) []string {
+		keys, err := client.listKeys(bucket, prefix)
+		Expect(err).NotTo(HaveOccurred())
+		return keys
+	}
+
+	It("reads metadata and node statuses of volumes", func() {
+		meta := &VolumeMeta{Driver: "ru.yandex.s3.csi", VolumeID: bucket + "/pvc-1", CapacityBytes: 1 << 30,
+			VolumeContext: map[string]string{"mounter": "geesefs"}}
+		Expect(client.CreatePrefix(bucket, "pvc-1")).To(Succeed())
+		Expect(client.WriteVolumeMeta(bucket, "pvc-1", meta)).To(Succeed())
+		now := time.Now().UTC().Truncate(time.Second)
+		Expect(client.WriteNodeStatus(bucket, "pvc-1", &NodeStatus{NodeID: "node-1", Message: "Volume is mounted", Time: now})).To(Succeed())
+		Expect(client.WriteNodeStatus(bucket, "pvc-1", &NodeStatus{NodeID: "node-2", Abnormal: true, Message: "failed", Time: now})).To(Succeed())
+
+		read, nodes, err := client.ReadVolumeMeta(bucket, "pvc-1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(read).To(Equal(meta))
+		Expect(nodes).To(ConsistOf(
+			NodeStatus{NodeID: "node-1", Message: "Volume is mounted", Time: now},
+			NodeStatus{NodeID: "node-2", Abnormal: true, Message: "failed", Time: now},
+		))
+
+		Expect(client.RemoveNodeStatus(bucket, "pvc-1", "node-2")).To(Succeed())
+		_, nodes, err = client.ReadVolumeMeta(bucket, "pvc-1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(nodes).To(HaveLen(1))
+	})
+
+	It("stores metadata outside of prefixes of volumes", func() {
+		Expect(client.CreatePrefix(bucket, "pvc-1")).To(Succeed())
+		Expect(client.WriteVolumeMeta(bucket, "pvc-1", &VolumeMeta{VolumeID: bucket + "/pvc-1"})).To(Succeed())
+		Expect(client.WriteNodeStatus(bucket, "pvc-1", &NodeStatus{NodeID: "node-1"})).To(Succeed())
+		Expect(listKeys("pvc-1")).To(Equal([]string{"pvc-1/"}))
+
+		Expect(client.RemoveVolumeMeta(bucket, "pvc-1")).To(Succeed())
+		Expect(listKeys(metadataDir)).To(BeEmpty())
+	})
+
+	It("separates metadata of bucket volumes and volumes with prefixes", func() {
+		Expect(client.WriteVolumeMeta(bucket, "", &VolumeMeta{VolumeID: bucket})).To(Succeed())
+		Expect(client.WriteNodeStatus(bucket, "", &NodeStatus{NodeID: "node-1"})).To(Succeed())
+		Expect(client.WriteVolumeMeta(bucket, "pvc-1", &VolumeMeta{VolumeID: bucket + "/pvc-1"})).To(Succeed())
+
+		meta, nodes, err := client.ReadVolumeMeta(bucket, "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(meta.VolumeID).To(Equal(bucket))
+		Expect(nodes).To(HaveLen(1))
+		meta, nodes, err = client.ReadVolumeMeta(bucket, "pvc-1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(meta.VolumeID).To(Equal(bucket + "/pvc-1"))
+		Expect(nodes).To(BeEmpty())
+	})
+
+	It("returns no metadata for prefixes which aren't volumes", func() {
+		Expect(client.CreatePrefix(bucket, "data")).To(Succeed())
+		meta, nodes, err := client.ReadVolumeMeta(bucket, "data")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(meta).To(BeNil())
+		Expect(nodes).To(BeNil())
+	})
+})
+
+var _ = Describe("ListVolumes", func() {
+	const driver = "ru.yandex.s3.csi"
+	buckets := []string{"test-list-a", "test-list-b", "test-list-c"}
+	var client *s3Client
+
+	BeforeEach(func() {
+		client = newTestClient()
+		for _, bucket := range buckets {
+			newTestBucket(client, bucket)
+		}
+		for _, prefix := range []string{"pvc-2", "pvc-1", "pvc-3"} {
+			Expect(client.WriteVolumeMeta("test-list-a", prefix, &VolumeMeta{Driver: driver, VolumeID: "test-list-a/" + prefix})).To(Succeed())
+		}
+		Expect(client.WriteNodeStatus("test-list-a", "pvc-1", &NodeStatus{NodeID: "node-1"})).To(Succeed())
+		// A status left by a node after the volume was deleted
+		Expect(client.WriteNodeStatus("test-list-a", "pvc-0", &NodeStatus{NodeID: "node-1"})).To(Succeed())
+		Expect(client.WriteVolumeMeta("test-list-b", "", &VolumeMeta{Driver: driver, VolumeID: "test-list-b"})).To(Succeed())
+		Expect(client.WriteVolumeMeta("test-list-c", "pvc-1", &VolumeMeta{Driver: "other.csi", VolumeID: "test-list-c/pvc-1"})).To(Succeed())
+	})
+
+	AfterEach(func() {
+		for _, bucket := range buckets {
+			Expect(client.RemoveBucket(bucket)).To(Succeed())
+		}
+	})
+
+	// list returns IDs of test volumes from a page
+	list := func(afterBucket, afterPrefix string, limit int) ([]string, bool) {
+		volumes, more, err := client.ListVolumes(driver, afterBucket, afterPrefix, limit)
+		Expect(err).NotTo(HaveOccurred())
+		var ids []string
+		for _, volume := range volumes {
+			if volume.BucketName >= "test-list-" && volume.BucketName < "test-list-~" {
+				ids = append(ids, volume.Meta.VolumeID)
+			}
+		}
+		return ids, more
+	}
+
+	It("lists volumes of the driver in order", func() {
+		ids, _ := list("test-list-", "", 0)
+		Expect(ids).To(Equal([]string{"test-list-a/pvc-1", "test-list-a/pvc-2", "test-list-a/pvc-3", "test-list-b"}))
+	})
+
+	It("reads node statuses of volumes", func() {
+		volumes, _, err := client.ListVolumes(driver, "test-list-a", "pvc-0", 1)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(volumes).To(HaveLen(1))
+		Expect(volumes[0].Prefix).To(Equal("pvc-1"))
+		Expect(volumes[0].Nodes).To(Equal([]NodeStatus{{NodeID: "node-1"}}))
+	})
+
+	It("returns pages after a volume", func() {
+		ids, more := list("test-list-a", "pvc-1", 2)
+		Expect(ids).To(Equal([]string{"test-list-a/pvc-2", "test-list-a/pvc-3"}))
+		Expect(more).To(BeTrue())
+		ids, _ = list("test-list-a", "pvc-3", 1)
+		Expect(ids).To(Equal([]string{"test-list-b"}))
+	})
+
+	It("starts after a deleted volume", func() {
+		Expect(client.RemoveVolumeMeta("test-list-a", "pvc-2")).To(Succeed())
+		ids, _ := list("test-list-a", "pvc-2", 1)
+		Expect(ids).To(Equal([]string{"test-list-a/pvc-3"}))
+	})
+})
+
+var _ = Describe("groupMetadataKeys", func() {
+	It("groups keys by prefixes of volumes", func() {
+		Expect(groupMetadataKeys([]string{
+			".csi-s3/bucket/metadata.json",
+			".csi-s3/bucket/nodes/node-1.json",
+			".csi-s3/prefixes/pvc-1/metadata.json",
+			".csi-s3/prefixes/pvc-1/nodes/node-1.json",
+			".csi-s3/prefixes/pvc-2/nodes/node-2.json",
+			".csi-s3/prefixes/a/b/metadata.json",
+			".csi-s3/unknown",
+		})).To(Equal(map[string][]string{
+			"":      {".csi-s3/bucket/metadata.json", ".csi-s3/bucket/nodes/node-1.json"},
+			"pvc-1": {".csi-s3/prefixes/pvc-1/metadata.json", ".csi-s3/prefixes/pvc-1/nodes/node-1.json"},
+			"pvc-2": {".csi-s3/prefixes/pvc-2/nodes/node-2.json"},
+			"a/b":   {".csi-s3/prefixes/a/b/metadata.json"},
+		}))
+	})
+})
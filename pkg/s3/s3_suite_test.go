@@ -0,0 +1,36 @@
+package s3
+
+import (
+	"io/ioutil"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gopkg.in/yaml.v2"
+)
+
+func TestS3(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "S3")
+}
+
+// newTestClient returns a client of the S3 server used by tests
+func newTestClient() *s3Client {
+	buf, err := ioutil.ReadFile("../../test/secret.yaml")
+	Expect(err).NotTo(HaveOccurred())
+	var secrets map[string]map[string]string
+	Expect(yaml.Unmarshal(buf, &secrets)).To(Succeed())
+	client, err := NewClientFromSecret(secrets["CreateVolumeSecret"])
+	Expect(err).NotTo(HaveOccurred())
+	return client
+}
+
+// newTestBucket creates an empty bucket
+func newTestBucket(client *s3Client, name string) {
+	exists, err := client.BucketExists(name)
+	Expect(err).NotTo(HaveOccurred())
+	if exists {
+		Expect(client.RemoveBucket(name)).To(Succeed())
+	}
+	Expect(client.CreateBucket(name)).To(Succeed())
+}
@@ -0,0 +1,95 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/yandex-cloud/k8s-csi-s3/pkg/logging"
+)
+
+const (
+	tracerName = "github.com/yandex-cloud/k8s-csi-s3"
+	// Time to send pending spans on shutdown
+	shutdownTimeout = 5 * time.Second
+)
+
+// Init sends spans to an OTLP gRPC collector at endpoint like "localhost:4317".
+// Tracing is disabled if endpoint is empty. The returned function sends
+// pending spans and stops tracing.
+func Init(endpoint, serviceName, nodeID string) (func(), error) {
+	if endpoint == "" {
+		return func() {}, nil
+	}
+	exporter, err := otlptracegrpc.New(
+		context.Background(),
+		otlptracegrpc.WithEndpoint(endpoint),
+		otlptracegrpc.WithInsecure(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating OTLP exporter for %s: %v", endpoint, err)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceNameKey.String(serviceName),
+			semconv.HostNameKey.String(nodeID),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	logging.Infof("Sending traces to %s", endpoint)
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := provider.Shutdown(ctx); err != nil {
+			logging.Errorf("Error sending traces to %s: %v", endpoint, err)
+		}
+	}, nil
+}
+
+// Start starts a child span of the span in ctx, it does nothing if tracing is disabled
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End ends the span and marks it as failed if err is not nil
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Detach returns a context with the span of ctx, but without its deadline and
+// cancellation, for operations which must finish even if the RPC is cancelled
+func Detach(ctx context.Context) context.Context {
+	return DetachTo(context.Background(), ctx)
+}
+
+// DetachTo returns parent with the span of ctx, so that operations
+// are cancelled with parent, but are traced as children of ctx
+func DetachTo(parent, ctx context.Context) context.Context {
+	return trace.ContextWithSpan(parent, trace.SpanFromContext(ctx))
+}
+
+// TraceID returns the ID of the trace of ctx, or an empty string
+func TraceID(ctx context.Context) string {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return ""
+	}
+	return sc.TraceID().String()
+}
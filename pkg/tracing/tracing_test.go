@@ -0,0 +1,94 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/grpc"
+)
+
+func TestTracing(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Tracing")
+}
+
+// fakeCollector is an OTLP gRPC collector which keeps received spans
+type fakeCollector struct {
+	coltracepb.UnimplementedTraceServiceServer
+	mu    sync.Mutex
+	spans []*tracepb.Span
+}
+
+func (c *fakeCollector) Export(ctx context.Context, req *coltracepb.ExportTraceServiceRequest) (*coltracepb.ExportTraceServiceResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, rs := range req.ResourceSpans {
+		for _, ils := range rs.InstrumentationLibrarySpans {
+			c.spans = append(c.spans, ils.Spans...)
+		}
+	}
+	return &coltracepb.ExportTraceServiceResponse{}, nil
+}
+
+func (c *fakeCollector) span(name string) *tracepb.Span {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, s := range c.spans {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+var _ = Describe("Tracing", func() {
+	It("does nothing without an endpoint", func() {
+		stop, err := Init("", "test", "node")
+		Expect(err).NotTo(HaveOccurred())
+		ctx, span := Start(context.Background(), "noop")
+		Expect(TraceID(ctx)).To(BeEmpty())
+		End(span, nil)
+		stop()
+	})
+
+	It("sends spans to the collector", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		collector := &fakeCollector{}
+		server := grpc.NewServer()
+		coltracepb.RegisterTraceServiceServer(server, collector)
+		go server.Serve(listener)
+		defer server.Stop()
+
+		stop, err := Init(listener.Addr().String(), "test", "node")
+		Expect(err).NotTo(HaveOccurred())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		ctx, parent := Start(ctx, "parent")
+		Expect(TraceID(ctx)).To(HaveLen(32))
+		detached := Detach(ctx)
+		cancel()
+		Expect(detached.Err()).NotTo(HaveOccurred())
+		Expect(TraceID(detached)).To(Equal(TraceID(ctx)))
+		_, child := Start(detached, "child")
+		End(child, errors.New("failed"))
+		End(parent, nil)
+		stop()
+
+		p := collector.span("parent")
+		c := collector.span("child")
+		Expect(p).NotTo(BeNil())
+		Expect(c).NotTo(BeNil())
+		Expect(c.TraceId).To(Equal(p.TraceId))
+		Expect(c.ParentSpanId).To(Equal(p.SpanId))
+		Expect(c.Status.Code).To(Equal(tracepb.Status_STATUS_CODE_ERROR))
+		Expect(p.Status.Code).NotTo(Equal(tracepb.Status_STATUS_CODE_ERROR))
+	})
+})